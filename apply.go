@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// fieldManager is who we are to the API server when applying.
+// It owns every field we set so that fields we stop setting are removed
+// and changes made by others to those fields are reported.
+const fieldManager = "ci-bot"
+
+// applyPatchType is server-side apply, types.ApplyPatchType in newer
+// versions of apimachinery than ours
+const applyPatchType = types.PatchType("application/apply-patch+yaml")
+
+var (
+	serviceResource    = schema.GroupVersionResource{Version: "v1", Resource: "services"}
+	deploymentResource = schema.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"}
+)
+
+// applyConflictError is returned when a field we set is owned by
+// another manager, e.g. after someone edited it by hand.
+type applyConflictError struct {
+	Kind    string
+	Name    string
+	Message string
+}
+
+func (e applyConflictError) Error() string {
+	return e.Kind + " " + e.Name + " was changed outside of the bot and was not updated: " + e.Message
+}
+
+// applier applies objects with the dynamic client.
+// Our UpdateOptions have no FieldManager or Force yet so its
+// transport adds them to the apply requests.
+type applier struct {
+	client dynamic.Interface
+
+	// Takes over fields owned by others. Only used the first time we
+	// apply an object we used to Create and Update, as we owned its
+	// fields as an updater and would conflict with ourselves.
+	forced dynamic.Interface
+}
+
+func newApplier(config *rest.Config) (a applier, err error) {
+	a.client, err = dynamic.NewForConfig(applyConfig(config, false))
+	if err != nil {
+		return
+	}
+
+	a.forced, err = dynamic.NewForConfig(applyConfig(config, true))
+	return
+}
+
+// applyConfig() is the config with the apply parameters added to requests
+func applyConfig(config *rest.Config, force bool) *rest.Config {
+	config = rest.CopyConfig(config)
+
+	wrap := config.WrapTransport
+	config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		if wrap != nil {
+			rt = wrap(rt)
+		}
+		return applyTransport{rt: rt, force: force}
+	}
+
+	return config
+}
+
+// applyTransport adds the field manager, and force if set,
+// to server-side apply requests
+type applyTransport struct {
+	rt    http.RoundTripper
+	force bool
+}
+
+func (t applyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPatch || req.Header.Get("Content-Type") != string(applyPatchType) {
+		return t.rt.RoundTrip(req)
+	}
+
+	// A RoundTripper must not change the request it is given
+	req = req.Clone(req.Context())
+
+	query := req.URL.Query()
+	query.Set("fieldManager", fieldManager)
+	if t.force {
+		query.Set("force", "true")
+	}
+	req.URL.RawQuery = query.Encode()
+
+	return t.rt.RoundTrip(req)
+}
+
+// apply() sends the desired state of an object to the API server with
+// server-side apply. obj must have its apiVersion and kind set.
+// JSON is valid YAML so the object is sent as JSON.
+func (a applier) apply(gvr schema.GroupVersionResource,
+	namespace, name, kind string, obj interface{}) (err error) {
+
+	body, err := json.Marshal(obj)
+	if err != nil {
+		return
+	}
+
+	client := a.client
+
+	existing, err := a.client.Resource(gvr).Namespace(namespace).Get(name, metav1.GetOptions{})
+	switch {
+	case errors.IsNotFound(err):
+	case err != nil:
+		return
+	case !appliedBy(existing, fieldManager):
+		client = a.forced
+	}
+
+	_, err = client.Resource(gvr).Namespace(namespace).Patch(name, applyPatchType, body, metav1.UpdateOptions{})
+
+	if errors.IsConflict(err) {
+		err = applyConflictError{
+			Kind:    kind,
+			Name:    namespace + "/" + name,
+			Message: err.Error(),
+		}
+	}
+
+	return
+}
+
+// appliedBy() reports whether the manager has applied the object before
+func appliedBy(obj *unstructured.Unstructured, manager string) bool {
+	entries, _, _ := unstructured.NestedSlice(obj.Object, "metadata", "managedFields")
+
+	for _, entry := range entries {
+		fields, ok := entry.(map[string]interface{})
+		if ok && fields["manager"] == manager && fields["operation"] == "Apply" {
+			return true
+		}
+	}
+	return false
+}
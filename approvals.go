@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+)
+
+// approvalError is returned when owners try to deploy a build
+// that the approval policy of the project does not allow yet
+type approvalError struct {
+	Approved int
+	Required int
+	Blocked  bool
+}
+
+func (e approvalError) Error() string {
+	if e.Blocked {
+		return "This build was rejected by QA and cannot be deployed to production"
+	}
+	return fmt.Sprintf("This build needs %d QA approvals but has %d", e.Required, e.Approved)
+}
+
+// latestVerdicts() returns the last verdict of each QA member
+func (d Deployment) latestVerdicts() map[string]verdict {
+	latest := make(map[string]verdict)
+	for _, v := range d.Verdicts {
+		latest[v.User] = v
+	}
+	return latest
+}
+
+// approvals() counts the QA members who approved and rejected the build
+func (d Deployment) approvals() (approved, rejected int) {
+	for _, v := range d.latestVerdicts() {
+		if v.Approved {
+			approved++
+		} else {
+			rejected++
+		}
+	}
+	return
+}
+
+// blockedByReject() reports whether a rejection blocks the build
+// from going to production. Set "blockOnReject" on the project for this.
+func (d Deployment) blockedByReject() bool {
+	_, rejected := d.approvals()
+	return d.Build.Project.BlockOnReject && rejected > 0
+}
+
+// approvalsSatisfied() reports whether the approval policy of the
+// project allows the build to be deployed to production.
+// "requiredApprovals" on the project sets how many QA members must approve.
+func (d Deployment) approvalsSatisfied() bool {
+	approved, _ := d.approvals()
+	return !d.blockedByReject() && approved >= d.Build.Project.RequiredApprovals
+}
+
+// checkApprovals() returns an approvalError if the policy is not satisfied
+func (d Deployment) checkApprovals() error {
+	if d.approvalsSatisfied() {
+		return nil
+	}
+
+	approved, _ := d.approvals()
+	return approvalError{
+		Approved: approved,
+		Required: d.Build.Project.RequiredApprovals,
+		Blocked:  d.blockedByReject(),
+	}
+}
+
+// verdictState() is the state the deployment should be in
+// given the verdicts it has received so far
+func (d Deployment) verdictState() State {
+	approved, _ := d.approvals()
+
+	switch {
+	case d.blockedByReject():
+		return StateQARejected
+	case approved > 0 && d.approvalsSatisfied():
+		return StateQAApproved
+	}
+
+	return d.State
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"log"
+)
+
+// allowedUsers() returns who may respond to an interaction.
+// QA members answer "QA Response", the approvers of the environment
+// the build is in make the "Deploy Decision" and promote its "Canary",
+// and owners can "Rollback".
+// Owners and QA members can keep a preview alive.
+// We prefer the project as currently configured so that removing
+// someone from the config takes effect on messages already sent.
+func (s *server) allowedUsers(callbackID string, d Deployment) []string {
+	project, ok := s.Projects[d.Build.Project.ID]
+	if !ok {
+		project = d.Build.Project
+	}
+
+	switch callbackID {
+	case "QA Response":
+		return project.QA
+	case "Deploy Decision", "Canary":
+		return project.approvers(d.environment())
+	case "Rollback":
+		return project.Owners
+	case "Keep Alive":
+		return append(append([]string{}, project.Owners...), project.QA...)
+	}
+
+	return nil
+}
+
+// authorize() checks that the user who clicked is allowed to.
+// If not, it tells them so with an ephemeral message and records the attempt.
+func (s *server) authorize(action SlackInteraction, d Deployment) bool {
+	user := action.User["id"]
+
+	for _, allowed := range s.allowedUsers(action.CallbackID, d) {
+		if user == allowed {
+			return true
+		}
+	}
+
+	audit("denied %q by %s on deployment %s of project %s",
+		action.CallbackID, user, d.ID, d.Build.Project.ID)
+
+	err := sendEphemeral(action,
+		"Sorry, you are not allowed to do that for project "+d.Build.Project.Name)
+	if err != nil {
+		log.Println(err)
+	}
+
+	return false
+}
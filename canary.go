@@ -0,0 +1,282 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// CanarySpec makes production deploys start with a canary: the build
+// runs next to production and gets part of its traffic until an owner
+// promotes it to all of it or aborts it from Slack.
+// It needs "mapping" or "ingress" routing.
+type CanarySpec struct {
+	Weight int // percent of the traffic the canary starts with, no canary if 0
+}
+
+// canarySteps are the weights a canary can be promoted to.
+// At 100 the build replaces production and the canary is removed.
+var canarySteps = []int{50, 100}
+
+// nextCanaryWeight() is the weight the canary can be promoted to next
+func (d Deployment) nextCanaryWeight() int {
+	for _, weight := range canarySteps {
+		if weight > d.Canary.Weight {
+			return weight
+		}
+	}
+	return 100
+}
+
+// canarier() returns the deployer of the project if it can run canaries
+func (s *server) canarier(project Project) (Canarier, bool) {
+	deployer, err := s.deployer(project)
+	if err != nil {
+		return nil, false
+	}
+
+	canarier, ok := deployer.(Canarier)
+	return canarier, ok
+}
+
+// startCanary() deploys a deployment that is in the deploying-prod state
+// as a canary. It returns false if it should be promoted straight away
+// instead: the project has no canary, its deployer cannot run one or
+// there is nothing in production to share the traffic with.
+func (s *server) startCanary(d Deployment, user string) (started bool, errs []error) {
+	project := d.Build.Project
+
+	weight := project.Canary.Weight
+	if weight <= 0 || weight >= 100 {
+		return
+	}
+
+	canarier, ok := s.canarier(project)
+	if !ok {
+		return
+	}
+
+	_, hasLive, err := s.liveDeployment(project.ID)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+	if !hasLive {
+		return
+	}
+
+	started = true
+
+	var diagnostics *Diagnostics
+	deployErr := canarier.Canary(d.Build, weight)
+	if deployErr != nil {
+		// Production was not touched so there is nothing to roll back
+		deployer, _ := s.deployer(project)
+		diagnostics = diagnose(deployer, d.Build, environmentCanary)
+
+		err = canarier.RemoveCanary(d.Build)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		d.Canary = canaryDeploy{
+			By:        user,
+			Weight:    weight,
+			StartedAt: time.Now(),
+		}
+
+		if deployErr != nil {
+			d.Canary.Error = deployErr.Error()
+			d.Production = prodDeploy{By: user, Error: deployErr.Error()}
+			return d.Transition(StateFailed, "")
+		}
+		return d.Transition(StateCanary, "")
+	})
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	if deployErr != nil {
+		errs = append(errs, deployErr)
+		errs = append(errs, s.notify(Event{
+			Type:        EventProdDeployFailed,
+			Deployment:  d,
+			User:        user,
+			Err:         deployErr,
+			Diagnostics: diagnostics,
+		})...)
+		return
+	}
+
+	err = sendCanaryMessage(d)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, setOwnerMessagesStatus(d, getCanaryStatusAttachment(d))...)
+
+	return
+}
+
+// handleCanary() widens, promotes or aborts a canary
+func (s *server) handleCanary(action SlackInteraction, d Deployment) {
+
+	var errs []error
+
+	switch action.Actions[0].Name {
+	case "widen":
+		errs = s.widenCanary(action, d)
+	case "promote":
+		errs = s.promoteCanary(action, d)
+	case "abort":
+		errs = s.abortCanary(action, d)
+	}
+
+	if len(errs) > 0 {
+		log.Println(errs)
+	}
+}
+
+// widenCanary() sends more of the production traffic to the canary
+func (s *server) widenCanary(action SlackInteraction, d Deployment) (errs []error) {
+
+	if d.State != StateCanary {
+		err := transitionError{From: d.State, To: StateCanary}
+		replyIfIllegal(action, err)
+		errs = append(errs, err)
+		return
+	}
+
+	canarier, ok := s.canarier(d.Build.Project)
+	if !ok {
+		return
+	}
+
+	weight := d.nextCanaryWeight()
+
+	err := canarier.Canary(d.Build, weight)
+	if err != nil {
+		errs = append(errs, err)
+
+		replyErr := sendEphemeral(action, "Changing the weight of the canary failed: "+err.Error())
+		if replyErr != nil {
+			errs = append(errs, replyErr)
+		}
+		return
+	}
+
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		d.Canary.Weight = weight
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	updtMsg := getCanaryMessage(d)
+	updtMsg.Channel = action.Channel["id"]
+	updtMsg.Ts = action.MessageTs
+	updtMsg.Update = true
+
+	_, err = sendSlack(updtMsg)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	errs = append(errs, setOwnerMessagesStatus(d, getCanaryStatusAttachment(d))...)
+
+	return
+}
+
+// promoteCanary() replaces production with the build of the canary
+// and removes the canary. If that fails production is rolled back.
+func (s *server) promoteCanary(action SlackInteraction, d Deployment) (errs []error) {
+
+	user := action.User["id"]
+
+	unlock, err := s.lockProduction(d)
+	if err != nil {
+		replyIfIllegal(action, err)
+		errs = append(errs, err)
+		return
+	}
+	defer unlock()
+
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		return d.Transition(StateDeployingProd, user)
+	})
+	if err != nil {
+		replyIfIllegal(action, err)
+		errs = append(errs, err)
+		return
+	}
+
+	d, deployErrs := s.promote(d, user)
+	errs = append(errs, deployErrs...)
+
+	canarier, ok := s.canarier(d.Build.Project)
+	if ok {
+		err = canarier.RemoveCanary(d.Build)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	status := SlackAttachment{
+		Title:    "Promoted to 100% by <@" + user + ">",
+		Fallback: "Promoted to 100%",
+		Color:    "good",
+	}
+	if d.State != StateLive {
+		status = SlackAttachment{
+			Title:    "Replacing production failed, the canary was removed",
+			Fallback: "Replacing production failed",
+			Color:    "danger",
+		}
+	}
+
+	errs = append(errs, updateCanaryMessage(action, status)...)
+	errs = append(errs, setOwnerMessagesStatus(d, status)...)
+
+	return
+}
+
+// abortCanary() removes the canary and closes the deployment
+func (s *server) abortCanary(action SlackInteraction, d Deployment) (errs []error) {
+
+	user := action.User["id"]
+
+	d, err := s.Store.Update(d.ID, func(d *Deployment) error {
+		d.ClosedBy = user
+		d.ClosedAt = time.Now()
+		return d.Transition(StateClosed, user)
+	})
+	if err != nil {
+		replyIfIllegal(action, err)
+		errs = append(errs, err)
+		return
+	}
+
+	canarier, ok := s.canarier(d.Build.Project)
+	if ok {
+		err = canarier.RemoveCanary(d.Build)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	status := SlackAttachment{
+		Title:    "Canary aborted by <@" + user + ">",
+		Fallback: "Canary aborted",
+		Color:    "danger",
+	}
+
+	errs = append(errs, updateCanaryMessage(action, status)...)
+	errs = append(errs, setOwnerMessagesStatus(d, status)...)
+	errs = append(errs, s.teardownIfUnused(d)...)
+
+	return
+}
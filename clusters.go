@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/viper"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// ClusterConfig is a Kubernetes cluster builds can be deployed to.
+// Clusters are listed with "clusters" in the config and projects pick
+// one with "qaCluster" and "prodCluster", or per environment.
+type ClusterConfig struct {
+	Name           string
+	KubeConfigPath string // the cluster we run in if empty
+	Context        string // the current context of the kubeconfig if empty
+}
+
+// defaultClusterName is the cluster we use when none are listed
+const defaultClusterName = "default"
+
+// clusterConfigs() returns the clusters in the config.
+// Without any, there is a single "default" cluster in "KubeConfigPath".
+func clusterConfigs() (clusters []ClusterConfig, err error) {
+	err = viper.UnmarshalKey("clusters", &clusters)
+	if err != nil {
+		return
+	}
+
+	if len(clusters) == 0 {
+		clusters = []ClusterConfig{{
+			Name:           defaultClusterName,
+			KubeConfigPath: viper.GetString("KubeConfigPath"),
+		}}
+	}
+
+	return
+}
+
+// defaultCluster() is the cluster of projects that do not pick one.
+// It is "defaultCluster" in the config, or the first cluster listed.
+func defaultCluster() string {
+	if name := viper.GetString("defaultCluster"); name != "" {
+		return name
+	}
+
+	clusters, err := clusterConfigs()
+	if err != nil || len(clusters) == 0 {
+		return defaultClusterName
+	}
+	return clusters[0].Name
+}
+
+// cluster() is the name of the cluster the project deploys to in the environment
+func (p Project) cluster(environment string) string {
+	// The canary runs next to production
+	if environment == environmentCanary {
+		environment = environmentProduction
+	}
+
+	env, _ := p.environment(environment)
+	name := env.Cluster
+
+	switch {
+	case name != "":
+	case environment == environmentQA:
+		name = p.QACluster
+	case environment == environmentProduction:
+		name = p.ProdCluster
+	}
+
+	if name == "" {
+		return defaultCluster()
+	}
+	return name
+}
+
+// kubeCluster is a cluster we are connected to
+type kubeCluster struct {
+	name      string
+	clientset *kubernetes.Clientset
+	dynamic   dynamic.Interface // for resources we have no typed client for, e.g. routes
+	applier   applier
+}
+
+// connectCluster() connects to the cluster.
+// It fails if the cluster cannot be reached.
+func connectCluster(cc ClusterConfig) (c *kubeCluster, err error) {
+	config, err := cc.restConfig()
+	if err != nil {
+		return
+	}
+
+	c = &kubeCluster{name: cc.Name}
+
+	c.clientset, err = kubernetes.NewForConfig(config)
+	if err != nil {
+		return
+	}
+
+	c.dynamic, err = dynamic.NewForConfig(config)
+	if err != nil {
+		return
+	}
+
+	c.applier, err = newApplier(config)
+	if err != nil {
+		return
+	}
+
+	_, err = c.clientset.Discovery().ServerVersion()
+	if err != nil {
+		err = fmt.Errorf("Cannot reach the Kubernetes cluster %s at %s: %v", cc.Name, config.Host, err)
+	}
+
+	return
+}
+
+// restConfig() reads the kubeconfig of the cluster.
+// Without one we use the service account of the pod we run in.
+func (cc ClusterConfig) restConfig() (*rest.Config, error) {
+	if cc.KubeConfigPath == "" {
+		return rest.InClusterConfig()
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: cc.KubeConfigPath},
+		&clientcmd.ConfigOverrides{CurrentContext: cc.Context},
+	).ClientConfig()
+}
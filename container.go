@@ -0,0 +1,239 @@
+package main
+
+import (
+	"errors"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// ContainerSpec is how a project wants its container to run.
+// It is set with "container" on the project in the config.
+// Everything is optional, the defaults are what we always used:
+// port 80 and a single replica.
+type ContainerSpec struct {
+	Port        int32 // the port the container listens on
+	ServicePort int32 // the port the Service exposes
+
+	QAReplicas   int32
+	ProdReplicas int32
+
+	Requests Resources
+	Limits   Resources
+
+	Env     []EnvVar
+	Command []string
+	Args    []string
+
+	LivenessProbe  *ProbeSpec
+	ReadinessProbe *ProbeSpec
+}
+
+// Resources are CPU and memory quantities e.g. "250m" and "128Mi"
+type Resources struct {
+	CPU    string
+	Memory string
+}
+
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// ProbeSpec checks an HTTP path if Path is set,
+// runs Command if it is set or else opens a TCP connection.
+// The port defaults to the container port.
+type ProbeSpec struct {
+	Path    string
+	Port    int32
+	Command []string
+
+	InitialDelaySeconds int32
+	PeriodSeconds       int32
+	TimeoutSeconds      int32
+	FailureThreshold    int32
+}
+
+func (cs ContainerSpec) containerPort() int32 {
+	if cs.Port == 0 {
+		return 80
+	}
+	return cs.Port
+}
+
+func (cs ContainerSpec) servicePort() int32 {
+	if cs.ServicePort == 0 {
+		return 80
+	}
+	return cs.ServicePort
+}
+
+// replicas() returns the number of replicas for the environment
+func (cs ContainerSpec) replicas(environment string) int32 {
+	replicas := cs.QAReplicas
+	if environment == environmentProduction {
+		replicas = cs.ProdReplicas
+	}
+
+	if replicas < 1 {
+		return 1
+	}
+	return replicas
+}
+
+// container() builds the container for the image
+func (cs ContainerSpec) container(name, image string) (c apiv1.Container, err error) {
+	c = apiv1.Container{
+		Name:    name,
+		Image:   image,
+		Command: cs.Command,
+		Args:    cs.Args,
+		Ports: []apiv1.ContainerPort{
+			{
+				ContainerPort: cs.containerPort(),
+			},
+		},
+	}
+
+	for _, env := range cs.Env {
+		c.Env = append(c.Env, apiv1.EnvVar{Name: env.Name, Value: env.Value})
+	}
+
+	c.Resources.Requests, err = cs.Requests.resourceList()
+	if err != nil {
+		return
+	}
+
+	c.Resources.Limits, err = cs.Limits.resourceList()
+	if err != nil {
+		return
+	}
+
+	c.LivenessProbe = cs.LivenessProbe.probe(cs.containerPort())
+	c.ReadinessProbe = cs.ReadinessProbe.probe(cs.containerPort())
+
+	return
+}
+
+// resourceList() parses the quantities. It returns nil if none are set.
+func (r Resources) resourceList() (apiv1.ResourceList, error) {
+	if r.CPU == "" && r.Memory == "" {
+		return nil, nil
+	}
+
+	list := apiv1.ResourceList{}
+
+	if r.CPU != "" {
+		cpu, err := resource.ParseQuantity(r.CPU)
+		if err != nil {
+			return nil, err
+		}
+		list[apiv1.ResourceCPU] = cpu
+	}
+
+	if r.Memory != "" {
+		memory, err := resource.ParseQuantity(r.Memory)
+		if err != nil {
+			return nil, err
+		}
+		list[apiv1.ResourceMemory] = memory
+	}
+
+	return list, nil
+}
+
+func (ps *ProbeSpec) probe(defaultPort int32) *apiv1.Probe {
+	if ps == nil {
+		return nil
+	}
+
+	port := ps.Port
+	if port == 0 {
+		port = defaultPort
+	}
+
+	probe := &apiv1.Probe{
+		InitialDelaySeconds: ps.InitialDelaySeconds,
+		PeriodSeconds:       ps.PeriodSeconds,
+		TimeoutSeconds:      ps.TimeoutSeconds,
+		FailureThreshold:    ps.FailureThreshold,
+	}
+
+	switch {
+	case ps.Path != "":
+		probe.HTTPGet = &apiv1.HTTPGetAction{
+			Path: ps.Path,
+			Port: intstr.FromInt(int(port)),
+		}
+	case len(ps.Command) > 0:
+		probe.Exec = &apiv1.ExecAction{Command: ps.Command}
+	default:
+		probe.TCPSocket = &apiv1.TCPSocketAction{
+			Port: intstr.FromInt(int(port)),
+		}
+	}
+
+	return probe
+}
+
+// RuntimeConfig references existing Secrets and ConfigMaps to give the
+// container. Projects set it separately for QA and production with
+// "qaConfig" and "prodConfig" so previews never see production secrets.
+type RuntimeConfig struct {
+	Secrets    []string // added to the environment with envFrom
+	ConfigMaps []string // added to the environment with envFrom
+	Volumes    []VolumeSpec
+}
+
+// VolumeSpec mounts a Secret or a ConfigMap into the container
+type VolumeSpec struct {
+	Name      string
+	Secret    string
+	ConfigMap string
+	MountPath string
+	ReadOnly  bool
+}
+
+// apply() adds the config to the container and the pod
+func (rc RuntimeConfig) apply(c *apiv1.Container, pod *apiv1.PodSpec) (err error) {
+	for _, name := range rc.Secrets {
+		c.EnvFrom = append(c.EnvFrom, apiv1.EnvFromSource{
+			SecretRef: &apiv1.SecretEnvSource{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: name},
+			},
+		})
+	}
+
+	for _, name := range rc.ConfigMaps {
+		c.EnvFrom = append(c.EnvFrom, apiv1.EnvFromSource{
+			ConfigMapRef: &apiv1.ConfigMapEnvSource{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: name},
+			},
+		})
+	}
+
+	for _, v := range rc.Volumes {
+		volume := apiv1.Volume{Name: v.Name}
+
+		switch {
+		case v.Secret != "":
+			volume.Secret = &apiv1.SecretVolumeSource{SecretName: v.Secret}
+		case v.ConfigMap != "":
+			volume.ConfigMap = &apiv1.ConfigMapVolumeSource{
+				LocalObjectReference: apiv1.LocalObjectReference{Name: v.ConfigMap},
+			}
+		default:
+			return errors.New("Volume " + v.Name + " needs a secret or a configMap")
+		}
+
+		pod.Volumes = append(pod.Volumes, volume)
+		c.VolumeMounts = append(c.VolumeMounts, apiv1.VolumeMount{
+			Name:      v.Name,
+			MountPath: v.MountPath,
+			ReadOnly:  v.ReadOnly,
+		})
+	}
+
+	return
+}
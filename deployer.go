@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// Deployer puts builds somewhere people can reach them.
+// Each project picks one with "deployer" in the config,
+// the default is "kubernetes".
+type Deployer interface {
+	// Deploy creates or updates the QA preview of a build and returns its URL
+	Deploy(build Build) (url string, err error)
+
+	// Promote deploys a build to production, or to an environment
+	// between QA and production, and returns its URL
+	Promote(build Build, environment string) (url string, err error)
+
+	// Teardown removes the QA preview of a build
+	Teardown(build Build) error
+
+	// Status reports on the deployment of a build in an environment
+	Status(build Build, environment string) (DeployStatus, error)
+}
+
+// DeployStatus is what a Deployer knows about a running deployment
+type DeployStatus struct {
+	Exists        bool
+	Image         string
+	Replicas      int32
+	ReadyReplicas int32
+}
+
+// Ready() reports whether every replica is ready
+func (ds DeployStatus) Ready() bool {
+	return ds.Exists && ds.ReadyReplicas >= ds.Replicas
+}
+
+// Canarier is implemented by deployers that can send part of the
+// production traffic to a new build before replacing production with it
+type Canarier interface {
+	// Canary deploys the build next to production and sends it weight
+	// percent of the traffic. It is also used to change the weight.
+	Canary(build Build, weight int) error
+
+	// RemoveCanary deletes the canary of the project of the build
+	RemoveCanary(build Build) error
+}
+
+// Diagnoser is implemented by deployers that can help explain
+// why a deploy failed
+type Diagnoser interface {
+	Diagnose(build Build, environment string) (Diagnostics, error)
+}
+
+// Diagnostics are collected after a failed deploy
+type Diagnostics struct {
+	Source string   // e.g. the pod the logs are from
+	Logs   string   // the last lines logged by the failing container
+	Events []string // recent events, oldest first
+}
+
+// diagnose() collects diagnostics if the deployer supports it.
+// It returns nil if there is nothing to show.
+func diagnose(deployer Deployer, build Build, environment string) *Diagnostics {
+	diagnoser, ok := deployer.(Diagnoser)
+	if !ok {
+		return nil
+	}
+
+	diagnostics, err := diagnoser.Diagnose(build, environment)
+	if err != nil {
+		log.Println(err)
+	}
+
+	if diagnostics.Logs == "" && len(diagnostics.Events) == 0 {
+		return nil
+	}
+
+	return &diagnostics
+}
+
+// The first and last environments of every project,
+// see Project.environments()
+const (
+	environmentQA         = "qa"
+	environmentProduction = "production"
+
+	// The canary runs next to production, see Canarier
+	environmentCanary = "canary"
+)
+
+type Deployers map[string]Deployer
+
+// This registers the deployers projects can choose from
+func (s *server) addDeployers() (err error) {
+	kubernetes, err := newKubernetesDeployer()
+	if err != nil {
+		return
+	}
+	s.Deployers["kubernetes"] = kubernetes
+
+	return
+}
+
+// deployer() returns the deployer configured for the project
+func (s *server) deployer(project Project) (Deployer, error) {
+	name := project.Deployer
+	if name == "" {
+		name = "kubernetes"
+	}
+
+	d, ok := s.Deployers[name]
+	if !ok {
+		return nil, errors.New("Deployer " + name + " not found for project " + project.ID)
+	}
+
+	return d, nil
+}
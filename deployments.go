@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Deployment is everything the bot knows about a build, from the moment
+// it is received to its production deploy. It is kept in the Store.
+// Slack buttons only carry its ID, which we resolve from our own state
+// so nothing in an interaction can change what gets deployed.
+type Deployment struct {
+	ID         string       `json:"id"`
+	Build      Build        `json:"build"`
+	State      State        `json:"state"`
+	History    []transition `json:"history,omitempty"`
+	ReceivedAt time.Time    `json:"received_at"`
+	UpdatedAt  time.Time    `json:"updated_at"`
+
+	// The message in the project channel that tracks the QA deploy
+	ChannelTs string `json:"channel_ts,omitempty"`
+
+	URL           string     `json:"url,omitempty"`
+	QADeployedAt  time.Time  `json:"qa_deployed_at,omitempty"`
+	QAError       string     `json:"qa_error,omitempty"`
+	OwnerMessages []ownerMsg `json:"owner_messages,omitempty"`
+	Verdicts      []verdict  `json:"verdicts,omitempty"`
+
+	// Deploys to the environments between QA and production, in order
+	Promotions []promotion `json:"promotions,omitempty"`
+
+	Canary     canaryDeploy `json:"canary,omitempty"`
+	Production prodDeploy   `json:"production,omitempty"`
+
+	// Attempts to roll production back to this deployment
+	Rollbacks []rollback `json:"rollbacks,omitempty"`
+
+	ClosedBy string    `json:"closed_by,omitempty"`
+	ClosedAt time.Time `json:"closed_at,omitempty"`
+
+	// For deleting idle QA previews
+	ReapWarnedAt time.Time `json:"reap_warned_at,omitempty"`
+	KeepAliveAt  time.Time `json:"keep_alive_at,omitempty"`
+}
+
+type ownerMsg struct {
+	Owner   string `json:"owner,omitempty"`
+	Ts      string `json:"ts,omitempty"`
+	Channel string `json:"channel,omitempty"`
+}
+
+// verdict is the response of a QA member
+type verdict struct {
+	User     string    `json:"user"`
+	Approved bool      `json:"approved"`
+	At       time.Time `json:"at"`
+}
+
+// promotion is the result of deploying to an environment
+// between QA and production
+type promotion struct {
+	Environment string    `json:"environment"`
+	By          string    `json:"by,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// canaryDeploy is the canary of a production deploy
+type canaryDeploy struct {
+	By        string    `json:"by,omitempty"`
+	Weight    int       `json:"weight,omitempty"` // percent of the traffic
+	StartedAt time.Time `json:"started_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// prodDeploy is the result of deploying to production
+type prodDeploy struct {
+	By         string    `json:"by,omitempty"`
+	URL        string    `json:"url,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	DeployedAt time.Time `json:"deployed_at,omitempty"`
+
+	// The deployment that was live before this one
+	Replaced string `json:"replaced,omitempty"`
+
+	// When the deploy failed, the deployment production was rolled back to
+	RolledBackTo  string `json:"rolled_back_to,omitempty"`
+	RollbackError string `json:"rollback_error,omitempty"`
+}
+
+// rollback is an attempt to make a superseded deployment live again.
+// A failed one leaves the deployment superseded and its production
+// deploy as it was.
+type rollback struct {
+	By    string    `json:"by,omitempty"`
+	Error string    `json:"error,omitempty"`
+	At    time.Time `json:"at"`
+}
+
+// newDeploymentID() returns a random ID that cannot be guessed
+func newDeploymentID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
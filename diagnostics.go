@@ -0,0 +1,135 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/spf13/viper"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Diagnose() collects the last log lines of a failing pod of the build
+// and the recent events of the Deployment and that pod.
+// The number of log lines is "diagnosticLogLines" in the config, 50 by default.
+func (k *kubernetesDeployer) Diagnose(build Build, environment string) (
+	diagnostics Diagnostics, err error) {
+
+	c, err := k.cluster(build, environment)
+	if err != nil {
+		return
+	}
+
+	Id, namespace := locate(build, environment)
+
+	deployment, err := c.clientset.AppsV1().Deployments(namespace).Get(Id, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+
+	failure, err := podFailure(c.clientset, deployment)
+	if err != nil {
+		return
+	}
+
+	objects := []string{Id}
+
+	if failure != nil && failure.Pod != "" {
+		diagnostics.Source = "pod " + failure.Pod
+		diagnostics.Logs, err = podLogs(c.clientset, namespace, failure.Pod)
+		if err != nil {
+			return
+		}
+		objects = append(objects, failure.Pod)
+	} else {
+		// Without a failing pod, the logs of any pod of the build are
+		// better than nothing. Those of the previous version are not.
+		pods, listErr := newPods(c.clientset, deployment)
+		if listErr != nil {
+			err = listErr
+			return
+		}
+		if len(pods) > 0 {
+			pod := pods[0].Name
+			diagnostics.Source = "pod " + pod
+			diagnostics.Logs, err = podLogs(c.clientset, namespace, pod)
+			if err != nil {
+				return
+			}
+			objects = append(objects, pod)
+		}
+	}
+
+	diagnostics.Events, err = recentEvents(c.clientset, namespace, objects)
+	return
+}
+
+// podLogs() returns the last lines logged by the pod.
+// If the container restarted, the logs of the crashed one are more useful.
+func podLogs(clientset *kubernetes.Clientset, namespace, pod string) (string, error) {
+	lines := viper.GetInt64("diagnosticLogLines")
+	if lines <= 0 {
+		lines = 50
+	}
+
+	podClient := clientset.CoreV1().Pods(namespace)
+
+	p, err := podClient.Get(pod, metav1.GetOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	previous := false
+	for _, cs := range p.Status.ContainerStatuses {
+		if cs.RestartCount > 0 && !cs.Ready {
+			previous = true
+		}
+	}
+
+	logs, err := podClient.GetLogs(pod, &apiv1.PodLogOptions{
+		TailLines: &lines,
+		Previous:  previous,
+	}).Do().Raw()
+	if err != nil {
+		return "", err
+	}
+
+	return string(logs), nil
+}
+
+// recentEvents() returns the last events about the named objects
+func recentEvents(clientset *kubernetes.Clientset, namespace string,
+	names []string) (events []string, err error) {
+
+	var all []apiv1.Event
+	for _, name := range names {
+		list, err := clientset.CoreV1().Events(namespace).List(metav1.ListOptions{
+			FieldSelector: "involvedObject.name=" + name,
+		})
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, list.Items...)
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].LastTimestamp.Before(&all[j].LastTimestamp)
+	})
+
+	if len(all) > 10 {
+		all = all[len(all)-10:]
+	}
+
+	for _, e := range all {
+		events = append(events, strings.Join([]string{
+			e.LastTimestamp.Format("15:04:05"),
+			e.Type,
+			e.InvolvedObject.Kind + "/" + e.InvolvedObject.Name,
+			e.Reason + ":",
+			e.Message,
+		}, " "))
+	}
+
+	return
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// Environment is a step on the way from a QA preview to production.
+// Projects list them in order with "environments" in the config,
+// e.g. preview, staging and production. The first one is the QA preview
+// of each branch or tag, the last one is production and the ones in
+// between are shared by every build of the project.
+//
+// Without "environments" a project has a QA preview and production
+// set up with its other fields.
+type Environment struct {
+	Name string
+
+	// {{project}}, {{type}} and {{target}} are replaced
+	// e.g. "https://{{target}}.{{type}}.example.com" for the preview
+	URL string
+
+	Cluster string // see ClusterConfig
+
+	// Who can promote a build from this environment to the next,
+	// the owners of the project if empty
+	Approvers []string
+}
+
+// environments() returns the environments of the project in order.
+// Deployers know the first one as environmentQA, the last one as
+// environmentProduction and the others by their name.
+func (p Project) environments() []Environment {
+	if len(p.Environments) >= 2 {
+		return p.Environments
+	}
+
+	return []Environment{
+		{Name: environmentQA, Cluster: p.QACluster},
+		{Name: environmentProduction, URL: p.URL, Cluster: p.ProdCluster},
+	}
+}
+
+// environmentKeys() are the names deployers know the environments by
+func (p Project) environmentKeys() []string {
+	envs := p.environments()
+
+	keys := make([]string, len(envs))
+	for i, env := range envs {
+		switch i {
+		case 0:
+			keys[i] = environmentQA
+		case len(envs) - 1:
+			keys[i] = environmentProduction
+		default:
+			keys[i] = slugify(env.Name, maxNameLength)
+		}
+	}
+
+	return keys
+}
+
+// environment() returns the environment a deployer knows as key
+func (p Project) environment(key string) (env Environment, ok bool) {
+	for i, k := range p.environmentKeys() {
+		if k == key {
+			return p.environments()[i], true
+		}
+	}
+	return
+}
+
+// environmentName() is how we show the environment to people
+func (p Project) environmentName(key string) string {
+	env, ok := p.environment(key)
+	if !ok {
+		return key
+	}
+	return env.Name
+}
+
+// nextEnvironment() returns the environment a build goes to after key
+func (p Project) nextEnvironment(key string) (next string, ok bool) {
+	keys := p.environmentKeys()
+	for i, k := range keys {
+		if k == key && i+1 < len(keys) {
+			return keys[i+1], true
+		}
+	}
+	return
+}
+
+// approvers() returns who can promote builds out of the environment
+func (p Project) approvers(key string) []string {
+	env, ok := p.environment(key)
+	if !ok || len(env.Approvers) == 0 {
+		return p.Owners
+	}
+	return env.Approvers
+}
+
+// environmentURL() is where the build can be reached in the environment.
+// Without a URL pattern, QA previews are at <target>.<type>.<project URL>,
+// production at the project URL and others at <name>.<project URL>.
+func (p Project) environmentURL(key string, build Build) (string, error) {
+	env, _ := p.environment(key)
+
+	if env.URL != "" {
+		return strings.NewReplacer(
+			"{{project}}", p.ID,
+			"{{type}}", build.typeSlug(),
+			"{{target}}", build.slug(),
+		).Replace(env.URL), nil
+	}
+
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return "", err
+	}
+
+	switch key {
+	case environmentQA:
+		u.Host = build.slug() + "." + build.typeSlug() + "." + u.Host
+	case environmentProduction:
+	default:
+		u.Host = key + "." + u.Host
+	}
+
+	return u.String(), nil
+}
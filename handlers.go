@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 type Handlers map[string]func() http.HandlerFunc
@@ -50,7 +52,63 @@ func (s *server) addHandlers() {
 			build.Target = r.FormValue("target") // name of the branch or tag
 			build.Type = r.FormValue("type")     // branch or tag
 
-			s.Builds <- build
+			// Both end up in the names of the preview's resources
+			if build.Target == "" || build.Type == "" {
+				http.Error(w, "target and type are required", 400)
+				return
+			}
+			build.Slug = targetSlug(build.Target)
+
+			id, err := newDeploymentID()
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Error encountered", 500)
+				return
+			}
+
+			err = s.Store.Save(Deployment{
+				ID:         id,
+				Build:      build,
+				State:      StateReceived,
+				ReceivedAt: time.Now(),
+			})
+			if err != nil {
+				log.Println(err)
+				http.Error(w, "Error encountered", 500)
+				return
+			}
+
+			s.Builds <- id
+			w.Write([]byte("Received successfully"))
+		}
+	}
+
+	s.Handlers["BuildDeleted"] = func() http.HandlerFunc {
+		// This handles notifications that a branch or tag was
+		// deleted or merged and removes its QA preview
+		return func(w http.ResponseWriter, r *http.Request) {
+
+			projectName := r.FormValue("project")
+			project, ok := s.Projects[projectName]
+			if !ok {
+				log.Println(errors.New("Project " + projectName + " not found"))
+				http.Error(w, "Error encountered", 500)
+				return
+			}
+
+			build := Build{}
+			build.Project = project
+			build.Target = r.FormValue("target") // name of the branch or tag
+			build.Type = r.FormValue("type")     // branch or tag
+
+			// Without both we would match every preview of the project
+			if build.Target == "" || build.Type == "" {
+				http.Error(w, "target and type are required", 400)
+				return
+			}
+			build.Slug = targetSlug(build.Target)
+
+			go s.teardownTarget(build, "The "+build.Type+" "+build.Target+" was deleted")
 			w.Write([]byte("Received successfully"))
 		}
 	}
@@ -78,4 +136,68 @@ func (s *server) addHandlers() {
 			}
 		}
 	}
+
+	s.Handlers["SlackCommands"] = func() http.HandlerFunc {
+		// This handles slash commands.
+		// "/rollback <project>" lists recent production deployments
+		// of the project with a button to roll back to each
+		return func(w http.ResponseWriter, r *http.Request) {
+
+			var message SlackMessage
+
+			switch r.FormValue("command") {
+			case "/rollback":
+				message = s.rollbackCommand(r.FormValue("user_id"),
+					strings.TrimSpace(r.FormValue("text")))
+			default:
+				message = SlackMessage{
+					ResponseType: "ephemeral",
+					Text:         "Unknown command " + r.FormValue("command"),
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			err := json.NewEncoder(w).Encode(message)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// rollbackCommand() answers "/rollback <project>" for owners of the project
+func (s *server) rollbackCommand(user, projectID string) SlackMessage {
+	project, ok := s.Projects[projectID]
+	if !ok {
+		return SlackMessage{
+			ResponseType: "ephemeral",
+			Text:         "Usage: /rollback <project>. Project " + projectID + " not found",
+		}
+	}
+
+	allowed := false
+	for _, owner := range project.Owners {
+		if owner == user {
+			allowed = true
+		}
+	}
+
+	if !allowed {
+		audit("denied /rollback by %s on project %s", user, projectID)
+		return SlackMessage{
+			ResponseType: "ephemeral",
+			Text:         "Sorry, you are not allowed to do that for project " + project.Name,
+		}
+	}
+
+	history, err := s.productionHistory(projectID, 5)
+	if err != nil {
+		log.Println(err)
+		return SlackMessage{
+			ResponseType: "ephemeral",
+			Text:         "Error encountered",
+		}
+	}
+
+	return getRollbackListMessage(project, history)
 }
@@ -2,11 +2,15 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func sendSuccessProdDeploy(payload actionPayload, user, url string) (err error) {
+func sendSuccessProdDeploy(d Deployment, user, url string) (err error) {
 
-	project := payload.Build.Project
+	project := d.Build.Project
 
 	var newM SlackMessage
 	newM.Channel = project.Channel
@@ -14,7 +18,7 @@ func sendSuccessProdDeploy(payload actionPayload, user, url string) (err error)
 
 	newM.Attachments = []SlackAttachment{
 		SlackAttachment{
-			Fallback: "Project: " + project.Name + " Image: " + payload.Build.Image + " By: <@" + user + ">",
+			Fallback: "Project: " + project.Name + " Image: " + d.Build.Image + " By: <@" + user + ">",
 			Fields: []SlackField{
 				SlackField{
 					Title: "Project",
@@ -23,7 +27,7 @@ func sendSuccessProdDeploy(payload actionPayload, user, url string) (err error)
 				},
 				SlackField{
 					Title: "Docker Image",
-					Value: payload.Build.Image,
+					Value: d.Build.Image,
 					Short: false,
 				},
 				SlackField{
@@ -31,6 +35,11 @@ func sendSuccessProdDeploy(payload actionPayload, user, url string) (err error)
 					Value: "<@" + user + ">",
 					Short: true,
 				},
+				SlackField{
+					Title: "Cluster",
+					Value: project.cluster(environmentProduction),
+					Short: true,
+				},
 			},
 		},
 		SlackAttachment{
@@ -46,21 +55,34 @@ func sendSuccessProdDeploy(payload actionPayload, user, url string) (err error)
 		},
 	}
 
+	if d.Production.Replaced != "" {
+		newM.Attachments = append(newM.Attachments, SlackAttachment{
+			Fallback:   "Rollback to the previous image.",
+			CallbackID: "Rollback",
+			Actions:    []SlackAction{getRollbackAction(d.Production.Replaced, "Rollback")},
+		})
+	}
+
 	_, err = sendSlack(newM)
 	return
 }
 
-func sendFailedProdDeploy(payload actionPayload, deployErr error) (err error) {
+// sendPromotionMessage() tells the project channel that a build was
+// deployed to an environment between QA and production, or failed to be
+func sendPromotionMessage(d Deployment, environment, user string,
+	promoteErr error) (ts string, err error) {
 
-	project := payload.Build.Project
+	project := d.Build.Project
+	name := project.environmentName(environment)
+	p, _ := d.lastPromotion(environment)
 
 	var newM SlackMessage
 	newM.Channel = project.Channel
-	newM.Text = "Production deployment failed for project " + project.Name
+	newM.Text = "Project " + project.Name + " promoted to " + name + " by <@" + user + ">"
 
 	newM.Attachments = []SlackAttachment{
 		SlackAttachment{
-			Fallback: "Project: " + project.Name + " Image: " + payload.Build.Image,
+			Fallback: "Project: " + project.Name + " Image: " + d.Build.Image + " Environment: " + name,
 			Fields: []SlackField{
 				SlackField{
 					Title: "Project",
@@ -69,28 +91,363 @@ func sendFailedProdDeploy(payload actionPayload, deployErr error) (err error) {
 				},
 				SlackField{
 					Title: "Docker Image",
-					Value: payload.Build.Image,
+					Value: d.Build.Image,
 					Short: false,
 				},
+				SlackField{
+					Title: "Environment",
+					Value: name,
+					Short: true,
+				},
+				SlackField{
+					Title: "Cluster",
+					Value: project.cluster(environment),
+					Short: true,
+				},
+			},
+		},
+	}
+
+	if promoteErr != nil {
+		newM.Text = "Promoting project " + project.Name + " to " + name + " failed"
+		newM.Attachments = append(newM.Attachments, getFailureAttachment(promoteErr))
+	} else {
+		newM.Attachments = append(newM.Attachments, SlackAttachment{
+			Fallback: "View project: " + p.URL,
+			Color:    "good",
+			Actions: []SlackAction{
+				SlackAction{
+					Type: "button",
+					Text: "View project",
+					URL:  p.URL,
+				},
+			},
+		})
+	}
+
+	resp, err := sendSlack(newM)
+	if err != nil {
+		return
+	}
+
+	respMap := make(map[string]string)
+	json.Unmarshal(resp, &respMap)
+	ts = respMap["ts"]
+
+	return
+}
+
+// sendCanaryMessage() lets the project channel know a canary started
+// with the buttons to promote or abort it
+func sendCanaryMessage(d Deployment) (err error) {
+	msg := getCanaryMessage(d)
+	msg.Channel = d.Build.Project.Channel
+
+	_, err = sendSlack(msg)
+	return
+}
+
+func getCanaryMessage(d Deployment) SlackMessage {
+
+	project := d.Build.Project
+	weight := strconv.Itoa(d.Canary.Weight)
+
+	decision := SlackAttachment{
+		Fallback:   "Promote or abort the canary.",
+		CallbackID: "Canary",
+	}
+
+	if next := d.nextCanaryWeight(); next < 100 {
+		decision.Actions = append(decision.Actions, SlackAction{
+			Type:  "button",
+			Text:  "Promote to " + strconv.Itoa(next) + "%",
+			Name:  "widen",
+			Value: d.ID,
+		})
+	}
+
+	decision.Actions = append(decision.Actions,
+		SlackAction{
+			Type:  "button",
+			Text:  "Promote to 100%",
+			Name:  "promote",
+			Value: d.ID,
+			Style: "primary",
+			Confirm: map[string]string{
+				"title":        "Are you sure?",
+				"text":         "This will replace production with this build.",
+				"ok_text":      "Promote",
+				"dismiss_text": "Cancel",
+			},
+		},
+		SlackAction{
+			Type:  "button",
+			Text:  "Abort",
+			Name:  "abort",
+			Value: d.ID,
+			Style: "danger",
+			Confirm: map[string]string{
+				"title":        "Are you sure?",
+				"text":         "This will remove the canary and close this deployment.",
+				"ok_text":      "Abort",
+				"dismiss_text": "Cancel",
+			},
+		},
+	)
+
+	return SlackMessage{
+		Text: "Canary of project " + project.Name + " is getting " + weight + "% of production traffic",
+		Attachments: []SlackAttachment{
+			SlackAttachment{
+				Fallback: "Project: " + project.Name + " Image: " + d.Build.Image + " Weight: " + weight + "%",
+				Fields: []SlackField{
+					SlackField{
+						Title: "Project",
+						Value: project.Name,
+						Short: false,
+					},
+					SlackField{
+						Title: "Docker Image",
+						Value: d.Build.Image,
+						Short: false,
+					},
+					SlackField{
+						Title: "By",
+						Value: "<@" + d.Canary.By + ">",
+						Short: true,
+					},
+					SlackField{
+						Title: "Cluster",
+						Value: project.cluster(environmentProduction),
+						Short: true,
+					},
+				},
 			},
+			decision,
 		},
+	}
+}
+
+// getCanaryStatusAttachment() is shown on the owner messages
+// while the build is a canary
+func getCanaryStatusAttachment(d Deployment) SlackAttachment {
+	weight := strconv.Itoa(d.Canary.Weight)
+
+	return SlackAttachment{
+		Title:    "Canary getting " + weight + "% of production traffic",
+		Fallback: "Canary at " + weight + "%",
+		Color:    "warning",
+	}
+}
+
+// updateCanaryMessage() replaces the buttons of the canary message
+// once it was promoted or aborted
+func updateCanaryMessage(action SlackInteraction, status SlackAttachment) (errs []error) {
+	updtMsg := action.OrigMessage
+	updtMsg.Channel = action.Channel["id"]
+	updtMsg.Ts = action.MessageTs
+	updtMsg.Update = true
+	updtMsg.Attachments = updtMsg.Attachments[:1]
+	updtMsg.Attachments = append(updtMsg.Attachments, status)
+
+	_, err := sendSlack(updtMsg)
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return
+}
+
+// getRollbackAction() is a button to redeploy a previous
+// production deployment. Only owners can use it.
+func getRollbackAction(id, text string) SlackAction {
+	return SlackAction{
+		Type:  "button",
+		Text:  text,
+		Name:  "rollback",
+		Value: id,
+		Style: "danger",
+		Confirm: map[string]string{
+			"title":        "Are you sure?",
+			"text":         "This will replace what is currently in production.",
+			"ok_text":      "Rollback",
+			"dismiss_text": "Cancel",
+		},
+	}
+}
+
+// getRollbackListMessage() lists previous production deployments
+// with a button to roll back to each of them
+func getRollbackListMessage(project Project, history []Deployment) SlackMessage {
+	message := SlackMessage{
+		ResponseType: "ephemeral",
+		Text:         "Recent production deployments of " + project.Name,
+	}
+
+	if len(history) == 0 {
+		message.Text = "There are no production deployments of " + project.Name + " to roll back to"
+		return message
+	}
+
+	for _, d := range history {
+		attachment := SlackAttachment{
+			Title:      d.Build.Image,
+			Fallback:   "Image: " + d.Build.Image,
+			CallbackID: "Rollback",
+			Fields: []SlackField{
+				SlackField{
+					Title: "Deployed",
+					Value: d.Production.DeployedAt.Format("2006-01-02 15:04"),
+					Short: true,
+				},
+				SlackField{
+					Title: "By",
+					Value: "<@" + d.Production.By + ">",
+					Short: true,
+				},
+			},
+		}
+
+		if d.State == StateLive {
+			attachment.Color = "good"
+			attachment.Fields = append(attachment.Fields, SlackField{
+				Value: "Currently live",
+				Short: false,
+			})
+		} else {
+			attachment.Actions = []SlackAction{getRollbackAction(d.ID, "Rollback to this")}
+		}
+
+		message.Attachments = append(message.Attachments, attachment)
+	}
+
+	return message
+}
+
+func sendFailedProdDeploy(d Deployment, deployErr error) (ts string, err error) {
+
+	project := d.Build.Project
+
+	var newM SlackMessage
+	newM.Channel = project.Channel
+	newM.Text = "Production deployment failed for project " + project.Name
+
+	newM.Attachments = []SlackAttachment{
 		SlackAttachment{
-			Fallback: "Failure Reason: " + deployErr.Error(),
-			Color:    "danger",
+			Fallback: "Project: " + project.Name + " Image: " + d.Build.Image,
 			Fields: []SlackField{
 				SlackField{
-					Title: "Failure Reason",
-					Value: deployErr.Error(),
+					Title: "Project",
+					Value: project.Name,
+					Short: false,
+				},
+				SlackField{
+					Title: "Docker Image",
+					Value: d.Build.Image,
 					Short: false,
 				},
+				SlackField{
+					Title: "Cluster",
+					Value: project.cluster(environmentProduction),
+					Short: true,
+				},
+			},
+		},
+		getFailureAttachment(deployErr),
+	}
+
+	resp, err := sendSlack(newM)
+	if err != nil {
+		return
+	}
+
+	respMap := make(map[string]string)
+	json.Unmarshal(resp, &respMap)
+	ts = respMap["ts"]
+
+	return
+}
+
+// sendProdRollbackMessage() tells the project channel that production
+// was rolled back to the deployment, or that the rollback failed too
+func sendProdRollbackMessage(d Deployment, rollbackErr error) (err error) {
+
+	project := d.Build.Project
+
+	var newM SlackMessage
+	newM.Channel = project.Channel
+	newM.Text = "Production for project " + project.Name + " was rolled back to the previous image"
+
+	attachment := SlackAttachment{
+		Fallback: "Project: " + project.Name + " Image: " + d.Build.Image,
+		Color:    "warning",
+		Fields: []SlackField{
+			SlackField{
+				Title: "Project",
+				Value: project.Name,
+				Short: false,
+			},
+			SlackField{
+				Title: "Docker Image",
+				Value: d.Build.Image,
+				Short: false,
+			},
+			SlackField{
+				Title: "Cluster",
+				Value: project.cluster(environmentProduction),
+				Short: true,
 			},
 		},
 	}
 
+	newM.Attachments = []SlackAttachment{attachment}
+
+	if rollbackErr != nil {
+		newM.Text = "Rolling back production for project " + project.Name + " failed :fire:"
+		newM.Attachments = append(newM.Attachments, getFailureAttachment(rollbackErr))
+	}
+
 	_, err = sendSlack(newM)
 	return
 }
 
+// sendDiagnostics() posts the logs and events of a failed deploy
+// as a threaded reply to the failure message
+func sendDiagnostics(channel, ts string, diagnostics Diagnostics) (err error) {
+	// Slack truncates long messages so we keep the end of the logs
+	logs := diagnostics.Logs
+	if len(logs) > 3000 {
+		logs = "..." + logs[len(logs)-3000:]
+	}
+
+	var text string
+	if logs != "" {
+		text += "*Logs from " + diagnostics.Source + "*\n```" + logs + "```\n"
+	}
+	if len(diagnostics.Events) > 0 {
+		text += "*Recent events*\n```" + strings.Join(diagnostics.Events, "\n") + "```"
+	}
+
+	_, err = sendSlack(SlackMessage{
+		Channel:  channel,
+		ThreadTs: ts,
+		Text:     text,
+	})
+	return
+}
+
+// sendEphemeral() replies to an interaction with a message
+// only the user who clicked can see
+func sendEphemeral(action SlackInteraction, text string) (err error) {
+	_, err = sendSlack(SlackMessage{
+		Channel:   action.Channel["id"],
+		User:      action.User["id"],
+		Ephemeral: true,
+		Text:      text,
+	})
+	return
+}
+
 func sendAttemptDeployMessage(build Build) (ts string, err error) {
 	msg := getAttemptDeployMessage(build)
 
@@ -131,7 +488,12 @@ func getAttemptDeployMessage(build Build) SlackMessage {
 					},
 					SlackField{
 						Title: "Target",
-						Value: build.Target,
+						Value: build.displayTarget(),
+						Short: true,
+					},
+					SlackField{
+						Title: "Cluster",
+						Value: build.Project.cluster(environmentQA),
 						Short: true,
 					},
 				},
@@ -174,7 +536,12 @@ func getDeploySuccessMessage(build Build, url string) SlackMessage {
 					},
 					SlackField{
 						Title: "Target",
-						Value: build.Target,
+						Value: build.displayTarget(),
+						Short: true,
+					},
+					SlackField{
+						Title: "Cluster",
+						Value: build.Project.cluster(environmentQA),
 						Short: true,
 					},
 				},
@@ -228,33 +595,29 @@ func getFailedDeployMessage(build Build, err error) SlackMessage {
 					},
 					SlackField{
 						Title: "Target",
-						Value: build.Target,
+						Value: build.displayTarget(),
 						Short: true,
 					},
-				},
-			},
-			SlackAttachment{
-				Fallback: "Failure Reason: " + err.Error(),
-				Color:    "danger",
-				Fields: []SlackField{
 					SlackField{
-						Title: "Failure Reason",
-						Value: err.Error(),
-						Short: false,
+						Title: "Cluster",
+						Value: build.Project.cluster(environmentQA),
+						Short: true,
 					},
 				},
 			},
+			getFailureAttachment(err),
 		},
 	}
 }
 
-func sendOwnerMessages(build Build, url string) (
-	payload actionPayload, errs []error) {
+// sendOwnerMessages() sends the owners a message with the deploy buttons
+// and records where they were sent in the deployment
+func sendOwnerMessages(d *Deployment) (errs []error) {
 	var oMsgs []ownerMsg
 
-	successMessage := getDeploySuccessMessage(build, url)
+	successMessage := getDeploySuccessMessage(d.Build, d.URL)
 
-	for _, user := range build.Project.Owners {
+	for _, user := range d.Build.Project.Owners {
 		successMessage.Channel = user
 		resp, err := sendSlack(successMessage)
 		if err != nil {
@@ -272,18 +635,10 @@ func sendOwnerMessages(build Build, url string) (
 		})
 	}
 
-	payload = actionPayload{
-		Build:         build,
-		OwnerMessages: oMsgs,
-	}
-
-	OwnerMessage, err := getOwnerMessage(build, url, payload)
-	if err != nil {
-		errs = append(errs, err)
-		return
-	}
+	d.OwnerMessages = oMsgs
+	OwnerMessage := getOwnerMessage(*d)
 
-	for _, oM := range payload.OwnerMessages {
+	for _, oM := range d.OwnerMessages {
 		OwnerMessage.Update = true
 		OwnerMessage.Channel = oM.Channel
 		OwnerMessage.Ts = oM.Ts
@@ -298,14 +653,10 @@ func sendOwnerMessages(build Build, url string) (
 	return
 }
 
-func getOwnerMessage(build Build, url string, payload actionPayload) (SlackMessage, error) {
+func getOwnerMessage(d Deployment) SlackMessage {
 
-	marshaledPayload, err := json.Marshal(payload)
-	if err != nil {
-		return SlackMessage{}, err
-	}
-
-	qaTeamAttachment := getQaSlackAttachment(build)
+	build, url := d.Build, d.URL
+	qaTeamAttachment := getQaSlackAttachment(d)
 
 	message := SlackMessage{
 		Text: "New Build complete.\nDeployment Successful! :sunglasses:",
@@ -330,7 +681,12 @@ func getOwnerMessage(build Build, url string, payload actionPayload) (SlackMessa
 					},
 					SlackField{
 						Title: "Target",
-						Value: build.Target,
+						Value: build.displayTarget(),
+						Short: true,
+					},
+					SlackField{
+						Title: "Cluster",
+						Value: build.Project.cluster(environmentQA),
 						Short: true,
 					},
 				},
@@ -347,53 +703,18 @@ func getOwnerMessage(build Build, url string, payload actionPayload) (SlackMessa
 				},
 			},
 			qaTeamAttachment,
-			SlackAttachment{
-				Fallback:   "Deploy to Production.",
-				CallbackID: "Deploy Decision",
-				Actions: []SlackAction{
-					SlackAction{
-						Type:  "button",
-						Text:  "Deploy to Production",
-						Name:  "deploy",
-						Value: string(marshaledPayload),
-						Style: "primary",
-						Confirm: map[string]string{
-							"title":        "Are you sure?",
-							"text":         "This will deploy to production. The process cannot be reversed.",
-							"ok_text":      "Deploy",
-							"dismiss_text": "Cancel",
-						},
-					},
-					SlackAction{
-						Type:  "button",
-						Text:  "Close",
-						Name:  "close",
-						Value: string(marshaledPayload),
-						Style: "danger",
-						Confirm: map[string]string{
-							"title":        "Are you sure?",
-							"text":         "This will close this deployment. The process cannot be reversed.",
-							"ok_text":      "Close",
-							"dismiss_text": "Cancel",
-						},
-					},
-				},
-			},
+			getDecisionAttachment(d),
 		},
 	}
 
-	return message, nil
+	return message
 }
 
-func sendQaMessages(build Build, url string, payload actionPayload) (errs []error) {
+func sendQaMessages(d Deployment) (errs []error) {
 
-	QAmsg, err := getQAMessage(build, url, payload)
-	if err != nil {
-		errs = append(errs, err)
-		return
-	}
+	QAmsg := getQAMessage(d)
 
-	for _, user := range build.Project.QA {
+	for _, user := range d.Build.Project.QA {
 		QAmsg.Channel = user
 		_, err := sendSlack(QAmsg)
 		if err != nil {
@@ -405,13 +726,9 @@ func sendQaMessages(build Build, url string, payload actionPayload) (errs []erro
 	return
 }
 
-func getQAMessage(build Build, url string, payload actionPayload) (SlackMessage, error) {
-
-	marshaledPayload, err := json.Marshal(payload)
-	if err != nil {
-		return SlackMessage{}, err
-	}
+func getQAMessage(d Deployment) SlackMessage {
 
+	build, url := d.Build, d.URL
 	message := SlackMessage{
 		Text: "New Build complete.\nDeployment Successful! :sunglasses:",
 		Attachments: []SlackAttachment{
@@ -435,7 +752,12 @@ func getQAMessage(build Build, url string, payload actionPayload) (SlackMessage,
 					},
 					SlackField{
 						Title: "Target",
-						Value: build.Target,
+						Value: build.displayTarget(),
+						Short: true,
+					},
+					SlackField{
+						Title: "Cluster",
+						Value: build.Project.cluster(environmentQA),
 						Short: true,
 					},
 				},
@@ -460,14 +782,14 @@ func getQAMessage(build Build, url string, payload actionPayload) (SlackMessage,
 						Type:  "button",
 						Text:  "Approve",
 						Name:  "approve",
-						Value: string(marshaledPayload),
+						Value: d.ID,
 						Style: "primary",
 					},
 					SlackAction{
 						Type:  "button",
 						Text:  "Reject",
 						Name:  "reject",
-						Value: string(marshaledPayload),
+						Value: d.ID,
 						Style: "danger",
 					},
 				},
@@ -475,22 +797,246 @@ func getQAMessage(build Build, url string, payload actionPayload) (SlackMessage,
 		},
 	}
 
-	return message, nil
+	return message
 }
 
-func getQaSlackAttachment(build Build) SlackAttachment {
+func getQaSlackAttachment(d Deployment) SlackAttachment {
 
 	qaTeamAttachment := SlackAttachment{
 		Title:    "QA to be done by:",
 		Fallback: "QA to be done by:",
 	}
 
-	for _, user := range build.Project.QA {
+	verdicts := d.latestVerdicts()
+
+	for _, user := range d.Build.Project.QA {
+		value := "<@" + user + ">"
+		if v, ok := verdicts[user]; ok && v.Approved {
+			value += " :white_check_mark:"
+		} else if ok {
+			value += " :x:"
+		}
+
 		qaTeamAttachment.Fields = append(qaTeamAttachment.Fields, SlackField{
-			Value: "<@" + user + ">",
+			Value: value,
 			Short: true,
 		})
 	}
 
 	return qaTeamAttachment
 }
+
+// getDecisionAttachment() has the buttons for the approvers.
+// "Promote to <next environment>" is only shown once the approval
+// policy of the project is satisfied.
+func getDecisionAttachment(d Deployment) SlackAttachment {
+
+	project := d.Build.Project
+	approved, _ := d.approvals()
+	required := project.RequiredApprovals
+
+	next, _ := project.nextEnvironment(d.environment())
+	nextName := project.environmentName(next)
+
+	attachment := SlackAttachment{
+		Fallback:   "Promote to " + nextName + ".",
+		CallbackID: "Deploy Decision",
+	}
+
+	for _, p := range d.Promotions {
+		if p.Error == "" {
+			attachment.Text += "Promoted to " + project.environmentName(p.Environment) +
+				" by <@" + p.By + ">: " + p.URL + "\n"
+		}
+	}
+
+	// A failed promotion leaves the build where it was so it can be retried
+	if p, ok := d.lastPromotion(next); ok && p.Error != "" {
+		attachment.Text += "Deploying to " + nextName + " failed: " + p.Error + "\n"
+		attachment.Color = "danger"
+	}
+
+	confirm := "This will deploy to " + nextName + "."
+	if next == environmentProduction {
+		confirm += " The process cannot be reversed."
+	}
+
+	switch {
+	case d.State == StateFailed:
+		attachment.Title = "Deploying to " + nextName + " failed."
+		attachment.Color = "danger"
+	case d.blockedByReject():
+		attachment.Title = "Rejected by QA. This build cannot be promoted."
+		attachment.Color = "danger"
+	case !d.approvalsSatisfied():
+		attachment.Title = fmt.Sprintf("Waiting for QA approval (%d/%d)", approved, required)
+		attachment.Color = "warning"
+	default:
+		attachment.Actions = append(attachment.Actions, SlackAction{
+			Type:  "button",
+			Text:  "Promote to " + nextName,
+			Name:  "promote",
+			Value: d.ID,
+			Style: "primary",
+			Confirm: map[string]string{
+				"title":        "Are you sure?",
+				"text":         confirm,
+				"ok_text":      "Promote",
+				"dismiss_text": "Cancel",
+			},
+		})
+	}
+
+	attachment.Actions = append(attachment.Actions, SlackAction{
+		Type:  "button",
+		Text:  "Close",
+		Name:  "close",
+		Value: d.ID,
+		Style: "danger",
+		Confirm: map[string]string{
+			"title":        "Are you sure?",
+			"text":         "This will close this deployment. The process cannot be reversed.",
+			"ok_text":      "Close",
+			"dismiss_text": "Cancel",
+		},
+	})
+
+	return attachment
+}
+
+// updateClosedOwnerMessages() replaces the buttons on the owner
+// messages once a deployment was closed by the bot
+func updateClosedOwnerMessages(d Deployment, reason string) (errs []error) {
+	return setOwnerMessagesStatus(d, SlackAttachment{
+		Title:    "Closed",
+		Text:     reason,
+		Fallback: "Closed: " + reason,
+		Color:    "danger",
+	})
+}
+
+// setOwnerMessagesStatus() replaces the buttons on the owner
+// messages with the status of the deployment
+func setOwnerMessagesStatus(d Deployment, status SlackAttachment) (errs []error) {
+	msg := getOwnerMessage(d)
+	msg.Update = true
+	msg.Attachments = msg.Attachments[:3]
+	msg.Attachments = append(msg.Attachments, status)
+
+	for _, oM := range d.OwnerMessages {
+		msg.Channel = oM.Channel
+		msg.Ts = oM.Ts
+
+		_, err := sendSlack(msg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return
+}
+
+// sendPreviewRemovedMessage() lets the project channel know in the
+// thread of the QA deploy that its preview was deleted
+func sendPreviewRemovedMessage(d Deployment, reason string) (err error) {
+	if d.ChannelTs == "" {
+		return
+	}
+
+	_, err = sendSlack(SlackMessage{
+		Channel:  d.Build.Project.Channel,
+		ThreadTs: d.ChannelTs,
+		Text:     reason + ". The preview at " + d.URL + " has been removed.",
+	})
+	return
+}
+
+// sendReapWarning() warns the project channel that an idle
+// preview will be deleted unless someone keeps it alive
+func sendReapWarning(d Deployment, warning time.Duration) (err error) {
+	build := d.Build
+
+	_, err = sendSlack(SlackMessage{
+		Channel: build.Project.Channel,
+		Text: "The preview of " + build.Type + " " + build.Target + " of project " +
+			build.Project.Name + " has been idle and will be deleted in " + warning.String(),
+		Attachments: []SlackAttachment{
+			SlackAttachment{
+				Fallback:   "Keep alive: " + d.URL,
+				CallbackID: "Keep Alive",
+				Actions: []SlackAction{
+					SlackAction{
+						Type: "button",
+						Text: "View project",
+						URL:  d.URL,
+					},
+					SlackAction{
+						Type:  "button",
+						Text:  "Keep alive",
+						Name:  "keep-alive",
+						Value: d.ID,
+						Style: "primary",
+					},
+				},
+			},
+		},
+	})
+	return
+}
+
+// updateOwnerMessages() redraws the owner messages from the deployment
+func updateOwnerMessages(d Deployment) (errs []error) {
+	msg := getOwnerMessage(d)
+	msg.Update = true
+
+	for _, oM := range d.OwnerMessages {
+		msg.Channel = oM.Channel
+		msg.Ts = oM.Ts
+
+		_, err := sendSlack(msg)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return
+}
+
+// getFailureAttachment() explains why a deploy failed.
+// When pods did not become ready we also show which one and why
+// e.g. ImagePullBackOff or CrashLoopBackOff.
+func getFailureAttachment(err error) SlackAttachment {
+	attachment := SlackAttachment{
+		Fallback: "Failure Reason: " + err.Error(),
+		Color:    "danger",
+		Fields: []SlackField{
+			SlackField{
+				Title: "Failure Reason",
+				Value: err.Error(),
+				Short: false,
+			},
+		},
+	}
+
+	if rErr, ok := err.(rolloutError); ok {
+		attachment.Fields = append(attachment.Fields,
+			SlackField{
+				Title: "Pod Status",
+				Value: rErr.Reason,
+				Short: true,
+			},
+		)
+
+		if rErr.Pod != "" {
+			attachment.Fields = append(attachment.Fields,
+				SlackField{
+					Title: "Pod",
+					Value: rErr.Pod,
+					Short: true,
+				},
+			)
+		}
+	}
+
+	return attachment
+}
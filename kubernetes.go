@@ -0,0 +1,514 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// kubernetesDeployer deploys builds to Kubernetes clusters.
+// Traffic is routed to them as set by "routing" on the project.
+type kubernetesDeployer struct {
+	clusters map[string]*kubeCluster
+}
+
+// newKubernetesDeployer() connects to every cluster in the config.
+// It fails if one of them cannot be reached.
+func newKubernetesDeployer() (k *kubernetesDeployer, err error) {
+	configs, err := clusterConfigs()
+	if err != nil {
+		return
+	}
+
+	k = &kubernetesDeployer{clusters: make(map[string]*kubeCluster)}
+
+	for _, cc := range configs {
+		c, err := connectCluster(cc)
+		if err != nil {
+			return nil, err
+		}
+		k.clusters[cc.Name] = c
+	}
+
+	return
+}
+
+// cluster() returns the cluster of the build in the environment
+func (k *kubernetesDeployer) cluster(build Build, environment string) (*kubeCluster, error) {
+	name := build.Project.cluster(environment)
+
+	c, ok := k.clusters[name]
+	if !ok {
+		return nil, fmt.Errorf("Cluster %s not found for project %s", name, build.Project.ID)
+	}
+
+	return c, nil
+}
+
+// Deploy() takes a Build, deploys and return the URL
+// We have to generate an ID and the appropriate URL first
+func (k *kubernetesDeployer) Deploy(build Build) (URL string, err error) {
+
+	URL, err = build.Project.environmentURL(environmentQA, build)
+	if err != nil {
+		return
+	}
+
+	c, err := k.cluster(build, environmentQA)
+	if err != nil {
+		return
+	}
+
+	namespace := qaNamespace(build)
+	err = c.ensureNamespace(namespace)
+	if err != nil {
+		return
+	}
+
+	err = c.deployToUrl(deployTarget{
+		Image:     build.Image,
+		ID:        qaID(build),
+		URL:       URL,
+		Namespace: namespace,
+		Labels:    qaLabels(build),
+		Replicas:  build.Project.Container.replicas(environmentQA),
+		Container: build.Project.Container,
+		Config:    build.Project.QAConfig,
+		Routing:   build.Project.Routing,
+		Timeout:   rolloutTimeout(build.Project),
+	})
+	return
+}
+
+// Promote() is to depoly a build to production or to an
+// environment between QA and production.
+// Unlike Deploy(), it does not add any sepcial identifiers
+// to the url or ID.
+func (k *kubernetesDeployer) Promote(build Build, environment string) (URL string, err error) {
+
+	URL, err = build.Project.environmentURL(environment, build)
+	if err != nil {
+		return
+	}
+
+	c, err := k.cluster(build, environment)
+	if err != nil {
+		return
+	}
+
+	// Only production gets the production secrets
+	config := build.Project.QAConfig
+	if environment == environmentProduction {
+		config = build.Project.ProdConfig
+	}
+
+	Id, namespace := locate(build, environment)
+
+	// Like QA, the environments in between may have their own namespaces
+	switch environment {
+	case environmentProduction, environmentCanary:
+	default:
+		err = c.ensureNamespace(namespace)
+		if err != nil {
+			return
+		}
+	}
+
+	err = c.deployToUrl(deployTarget{
+		Image:     build.Image,
+		ID:        Id,
+		URL:       URL,
+		Namespace: namespace,
+		Labels:    envLabels(build, environment),
+		Replicas:  build.Project.Container.replicas(environment),
+		Container: build.Project.Container,
+		Config:    config,
+		Routing:   build.Project.Routing,
+		Timeout:   rolloutTimeout(build.Project),
+	})
+	return
+}
+
+// Teardown() deletes the QA Service, Deployment and route of a build.
+// They are found by their labels, or by their name for older previews.
+func (k *kubernetesDeployer) Teardown(build Build) (err error) {
+	c, err := k.cluster(build, environmentQA)
+	if err != nil {
+		return
+	}
+
+	namespace := qaNamespace(build)
+
+	err = c.deleteAll(namespace, qaLabels(build), build.Project.Routing)
+	if err != nil {
+		return
+	}
+
+	// Previews deployed before their resources were labelled
+	// can only be found by name
+	return c.deleteNamed(namespace, qaID(build))
+}
+
+// deleteNamed() deletes the Service and Deployment with the name.
+// It is not an error if they do not exist.
+func (c *kubeCluster) deleteNamed(namespace, name string) (err error) {
+	propagation := metav1.DeletePropagationForeground
+	deleteOptions := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	err = c.clientset.CoreV1().Services(namespace).Delete(name, deleteOptions)
+	if err != nil && !errors.IsNotFound(err) {
+		return
+	}
+
+	err = c.clientset.AppsV1().Deployments(namespace).Delete(name, deleteOptions)
+	if errors.IsNotFound(err) {
+		err = nil
+	}
+
+	return
+}
+
+// Canary() deploys the build next to production with its own
+// Service and a route that gets weight percent of the traffic
+func (k *kubernetesDeployer) Canary(build Build, weight int) (err error) {
+	URL, err := build.Project.environmentURL(environmentProduction, build)
+	if err != nil {
+		return
+	}
+
+	c, err := k.cluster(build, environmentProduction)
+	if err != nil {
+		return
+	}
+
+	Id, namespace := locate(build, environmentCanary)
+
+	err = c.deployToUrl(deployTarget{
+		Image:     build.Image,
+		ID:        Id,
+		URL:       URL,
+		Namespace: namespace,
+		Labels:    envLabels(build, environmentCanary),
+		Replicas:  build.Project.Container.replicas(environmentProduction),
+		Container: build.Project.Container,
+		Config:    build.Project.ProdConfig,
+		Routing:   build.Project.Routing,
+		Weight:    weight,
+		Timeout:   rolloutTimeout(build.Project),
+	})
+	return
+}
+
+// RemoveCanary() deletes the canary Service, Deployment and route
+func (k *kubernetesDeployer) RemoveCanary(build Build) (err error) {
+	c, err := k.cluster(build, environmentProduction)
+	if err != nil {
+		return
+	}
+
+	_, namespace := locate(build, environmentCanary)
+	return c.deleteAll(namespace, envLabels(build, environmentCanary), build.Project.Routing)
+}
+
+// deleteAll() deletes the Services, Deployments and routes with the labels
+func (c *kubeCluster) deleteAll(namespace string, set map[string]string,
+	routing RoutingSpec) (err error) {
+
+	selector := labels.SelectorFromSet(set).String()
+	listOptions := metav1.ListOptions{LabelSelector: selector}
+	propagation := metav1.DeletePropagationForeground
+	deleteOptions := &metav1.DeleteOptions{PropagationPolicy: &propagation}
+
+	svcClient := c.clientset.CoreV1().Services(namespace)
+	services, err := svcClient.List(listOptions)
+	if err != nil {
+		return
+	}
+
+	for _, svc := range services.Items {
+		err = svcClient.Delete(svc.Name, deleteOptions)
+		if err != nil && !errors.IsNotFound(err) {
+			return
+		}
+	}
+
+	depClient := c.clientset.AppsV1().Deployments(namespace)
+	err = depClient.DeleteCollection(deleteOptions, listOptions)
+	if err != nil {
+		return
+	}
+
+	gvr, ok := routing.resource()
+	if !ok {
+		return
+	}
+
+	err = c.dynamic.Resource(gvr).Namespace(namespace).DeleteCollection(
+		deleteOptions, listOptions)
+	return
+}
+
+// Status() reports on the Deployment of a build in the environment
+func (k *kubernetesDeployer) Status(build Build, environment string) (
+	status DeployStatus, err error) {
+
+	c, err := k.cluster(build, environment)
+	if err != nil {
+		return
+	}
+
+	Id, namespace := locate(build, environment)
+
+	depClient := c.clientset.AppsV1().Deployments(namespace)
+	deployment, err := depClient.Get(Id, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+
+	status.Exists = true
+	status.ReadyReplicas = deployment.Status.ReadyReplicas
+	if deployment.Spec.Replicas != nil {
+		status.Replicas = *deployment.Spec.Replicas
+	}
+	if len(deployment.Spec.Template.Spec.Containers) > 0 {
+		status.Image = deployment.Spec.Template.Spec.Containers[0].Image
+	}
+
+	return
+}
+
+// Previews() lists the QA Deployments in every namespace of every cluster
+func (k *kubernetesDeployer) Previews() (previews []Preview, err error) {
+	selector := labels.SelectorFromSet(map[string]string{
+		"environment": environmentQA,
+	}).String()
+
+	for _, c := range k.clusters {
+		deployments, err := c.clientset.AppsV1().Deployments(apiv1.NamespaceAll).List(
+			metav1.ListOptions{LabelSelector: selector},
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dep := range deployments.Items {
+			previews = append(previews, Preview{
+				Build: Build{
+					Project: Project{ID: dep.Labels["project"]},
+					Type:    dep.Labels["type"],
+					Target:  dep.Labels["target"],
+					Slug:    dep.Labels["target"],
+				},
+				CreatedAt: dep.CreationTimestamp.Time,
+			})
+		}
+	}
+
+	return
+}
+
+// locate() returns the name and namespace of the resources
+// of a build in the environment
+func locate(build Build, environment string) (Id, namespace string) {
+	switch environment {
+	case environmentQA:
+		return qaID(build), qaNamespace(build)
+	case environmentProduction:
+		return build.Project.ID, prodNamespace(build)
+	case environmentCanary:
+		return build.Project.ID + "-" + environmentCanary, prodNamespace(build)
+	}
+
+	// Environments in between may share the production namespace
+	Id = slugify(build.Project.ID+"-"+environment, maxNameLength)
+	namespace = namespaceFor(build.Project.ProdNamespace, build, environment)
+	return
+}
+
+// qaID() is the name of the QA resources of a build
+func qaID(build Build) string {
+	return slugify(build.Project.ID+"-"+build.typeSlug()+"-"+build.slug(), maxNameLength)
+}
+
+// namespaceFor() expands the namespace template of a project.
+// {{project}}, {{type}}, {{target}} and {{environment}} are replaced
+// and an empty template means the default namespace.
+// {{target}} is the slug of the target.
+func namespaceFor(template string, build Build, environment string) string {
+	if template == "" {
+		return apiv1.NamespaceDefault
+	}
+
+	return slugify(strings.NewReplacer(
+		"{{project}}", build.Project.ID,
+		"{{type}}", build.typeSlug(),
+		"{{target}}", build.slug(),
+		"{{environment}}", environment,
+	).Replace(template), maxNameLength)
+}
+
+func qaNamespace(build Build) string {
+	return namespaceFor(build.Project.QANamespace, build, environmentQA)
+}
+
+func prodNamespace(build Build) string {
+	return namespaceFor(build.Project.ProdNamespace, build, environmentProduction)
+}
+
+// ensureNamespace() creates the namespace if it does not exist yet
+func (c *kubeCluster) ensureNamespace(name string) (err error) {
+	nsClient := c.clientset.CoreV1().Namespaces()
+	_, err = nsClient.Get(name, metav1.GetOptions{})
+	if !errors.IsNotFound(err) {
+		return
+	}
+
+	_, err = nsClient.Create(&apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+			Labels: map[string]string{
+				"managed-by": "ci-bot",
+			},
+		},
+	})
+	if errors.IsAlreadyExists(err) {
+		err = nil
+	}
+
+	return
+}
+
+func qaLabels(build Build) map[string]string {
+	return map[string]string{
+		"project":     build.Project.ID,
+		"target":      build.slug(),
+		"type":        build.typeSlug(),
+		"environment": environmentQA,
+	}
+}
+
+// envLabels() are the labels of production and the
+// environments between QA and production
+func envLabels(build Build, environment string) map[string]string {
+	return map[string]string{
+		"project":     build.Project.ID,
+		"environment": environment,
+	}
+}
+
+// deployTarget is what deployToUrl() deploys and where
+type deployTarget struct {
+	Image     string
+	ID        string
+	URL       string
+	Namespace string
+	Labels    map[string]string
+	Replicas  int32
+	Container ContainerSpec
+	Config    RuntimeConfig
+	Routing   RoutingSpec
+	Weight    int           // percent of the traffic for a canary, 0 otherwise
+	Timeout   time.Duration // to wait for the rollout
+}
+
+// deployToUrl() is the generic deploy function.
+// The Service, Deployment and route are applied server-side so they
+// always end up as described here. It only returns once the rollout is complete.
+func (c *kubeCluster) deployToUrl(t deployTarget) (err error) {
+
+	Id, Namespace, labels := t.ID, t.Namespace, t.Labels
+
+	route, err := t.Routing.route(t)
+	if err != nil {
+		return
+	}
+
+	container, err := t.Container.container(Id, t.Image)
+	if err != nil {
+		return
+	}
+
+	var podSpec apiv1.PodSpec
+	err = t.Config.apply(&container, &podSpec)
+	if err != nil {
+		return
+	}
+	podSpec.Containers = []apiv1.Container{container}
+
+	service := &apiv1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        Id,
+			Namespace:   Namespace,
+			Labels:      labels,
+			Annotations: t.Routing.annotations(t),
+		},
+		Spec: apiv1.ServiceSpec{
+			Selector: labels,
+			Ports: []apiv1.ServicePort{
+				{
+					Port:       t.Container.servicePort(),
+					TargetPort: intstr.FromInt(int(t.Container.containerPort())),
+				},
+			},
+		},
+	}
+
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      Id,
+			Namespace: Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(t.Replicas),
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+			},
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: podSpec,
+			},
+		},
+	}
+
+	err = c.applier.apply(serviceResource, Namespace, Id, "Service", service)
+	if err != nil {
+		return
+	}
+
+	err = c.applier.apply(deploymentResource, Namespace, Id, "Deployment", deployment)
+	if err != nil {
+		return
+	}
+
+	if route != nil {
+		gvr, _ := t.Routing.resource()
+		err = c.applier.apply(gvr, Namespace, Id, route.GetKind(), route.Object)
+		if err != nil {
+			return
+		}
+	}
+
+	err = waitForRollout(c.clientset, Namespace, Id, t.Timeout)
+	return
+}
+
+func int32Ptr(i int32) *int32 { return &i }
@@ -13,6 +13,7 @@ type Build struct {
 	Target  string
 	Image   string
 	Type    string
+	Slug    string // the target made safe for names and hostnames
 }
 
 // We use viper here to load configuration from a config.yml file
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// maxNameLength is the longest Kubernetes name, label value
+// or hostname label we can use
+const maxNameLength = 63
+
+// slugify() makes a name safe to use for Kubernetes resources, labels
+// and hostnames. Names that are already safe are kept as they are.
+// Others are lowercased, anything but letters, digits and dashes becomes
+// a dash and a short hash of the original is added so that different
+// names cannot end up with the same slug. The result is at most max long
+// and never empty.
+func slugify(name string, max int) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+	slug := strings.Trim(b.String(), "-")
+
+	if slug != "" && slug == name && len(slug) <= max {
+		return slug
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:8]
+
+	if len(slug) > max-len(hash)-1 {
+		slug = strings.TrimRight(slug[:max-len(hash)-1], "-")
+	}
+	if slug == "" {
+		return hash
+	}
+
+	return slug + "-" + hash
+}
+
+// targetSlug() is the name we use for a branch or tag in Kubernetes
+// and in the preview hostname, e.g. feature/Foo_Bar is feature-foo-bar-04e454de
+func targetSlug(target string) string {
+	return slugify(target, maxNameLength)
+}
+
+// slug() returns the slug of the target of the build.
+// Builds received before slugs were stored do not have one.
+func (b Build) slug() string {
+	if b.Slug != "" {
+		return b.Slug
+	}
+	return targetSlug(b.Target)
+}
+
+// typeSlug() is the DNS-safe build type, e.g. branch or tag
+func (b Build) typeSlug() string {
+	return slugify(b.Type, maxNameLength)
+}
+
+// displayTarget() shows the target with its slug when they differ
+func (b Build) displayTarget() string {
+	slug := b.slug()
+	if slug == b.Target {
+		return b.Target
+	}
+	return b.Target + " (" + slug + ")"
+}
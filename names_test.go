@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlugify(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	// The hashes are part of the names of running previews,
+	// changing them would orphan those
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"master", "master"},
+		{"v1-2-0", "v1-2-0"},
+		{"feature/Foo_Bar", "feature-foo-bar-04e454de"},
+		{"v1.2.0", "v1-2-0-34bd659f"},
+		{"", "e3b0c442"},
+		{"///", "732c4e97"},
+		{long, strings.Repeat("a", 54) + "-6bd5e503"},
+	}
+
+	for _, test := range tests {
+		got := slugify(test.name, maxNameLength)
+		if got != test.want {
+			t.Errorf("slugify(%q) = %q, want %q", test.name, got, test.want)
+		}
+		if len(got) > maxNameLength {
+			t.Errorf("slugify(%q) = %q is longer than %d", test.name, got, maxNameLength)
+		}
+	}
+}
+
+func TestSlugifyDistinct(t *testing.T) {
+	long := strings.Repeat("a", 70)
+
+	tests := [][2]string{
+		{"feature/foo", "feature_foo"}, // differ in what becomes a dash
+		{"Foo", "foo"},                 // differ in case
+		{long + "1", long + "2"},       // differ past what fits
+	}
+
+	for _, test := range tests {
+		a, b := slugify(test[0], maxNameLength), slugify(test[1], maxNameLength)
+		if a == b {
+			t.Errorf("%q and %q have the same slug %q", test[0], test[1], a)
+		}
+	}
+}
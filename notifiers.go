@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// EventType is something that happened to a deployment
+// that people may want to hear about
+type EventType string
+
+const (
+	EventBuildReceived     EventType = "build_received"
+	EventQADeploySucceeded EventType = "qa_deploy_succeeded"
+	EventQADeployFailed    EventType = "qa_deploy_failed"
+	EventPromoted          EventType = "promoted" // to an environment between QA and production
+	EventPromoteFailed     EventType = "promote_failed"
+	EventProdDeployed      EventType = "prod_deployed"
+	EventProdDeployFailed  EventType = "prod_deploy_failed"
+
+	// Sent with the deployment production was rolled back to,
+	// and an error if the rollback also failed
+	EventProdRolledBack EventType = "prod_rolled_back"
+)
+
+// Event is sent to the notifiers of the project
+type Event struct {
+	Type       EventType
+	Deployment Deployment
+	User       string // who caused the event, empty if it was the bot
+	Err        error
+
+	// For promotions, the environment between QA and production
+	Environment string
+
+	// Collected for failed deploys when the deployer supports it
+	Diagnostics *Diagnostics
+}
+
+// Notifier tells people about deployment events.
+// Each project lists the notifiers it wants with "notifiers" in the
+// config, the default is only "slack".
+type Notifier interface {
+	Notify(event Event) error
+}
+
+type Notifiers map[string]Notifier
+
+// This registers the notifiers projects can choose from
+func (s *server) addNotifiers() {
+	s.Notifiers["slack"] = &slackNotifier{store: s.Store}
+	s.Notifiers["webhook"] = &webhookNotifier{}
+}
+
+// notify() sends the event to every notifier of the project.
+// A failing notifier does not stop the others.
+func (s *server) notify(event Event) (errs []error) {
+	project := event.Deployment.Build.Project
+
+	names := project.Notifiers
+	if len(names) == 0 {
+		names = []string{"slack"}
+	}
+
+	for _, name := range names {
+		notifier, ok := s.Notifiers[name]
+		if !ok {
+			errs = append(errs, errors.New("Notifier "+name+" not found for project "+project.ID))
+			continue
+		}
+
+		err := notifier.Notify(event)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		log.Println(errs)
+	}
+
+	return
+}
+
+// slackNotifier posts events to the channel of the project.
+// The QA deploy is tracked in a single message which is updated
+// as the deploy progresses.
+type slackNotifier struct {
+	store Store
+}
+
+func (sn *slackNotifier) Notify(event Event) (err error) {
+	d := event.Deployment
+
+	switch event.Type {
+	case EventBuildReceived:
+		// A resumed build already has a message in the channel
+		if d.ChannelTs != "" {
+			return
+		}
+
+		ts, err := sendAttemptDeployMessage(d.Build)
+		if err != nil {
+			return err
+		}
+
+		_, err = sn.store.Update(d.ID, func(d *Deployment) error {
+			d.ChannelTs = ts
+			return nil
+		})
+		return err
+
+	case EventQADeploySucceeded:
+		err = sendDeploySuccessMessage(d.Build, d.ChannelTs, d.URL)
+
+	case EventQADeployFailed:
+		err = sendFailedDeployMessage(d.Build, d.ChannelTs, event.Err)
+		if err == nil && event.Diagnostics != nil {
+			err = sendDiagnostics(d.Build.Project.Channel, d.ChannelTs, *event.Diagnostics)
+		}
+
+	case EventPromoted:
+		_, err = sendPromotionMessage(d, event.Environment, event.User, nil)
+
+	case EventPromoteFailed:
+		ts, err := sendPromotionMessage(d, event.Environment, event.User, event.Err)
+		if err == nil && event.Diagnostics != nil {
+			err = sendDiagnostics(d.Build.Project.Channel, ts, *event.Diagnostics)
+		}
+		return err
+
+	case EventProdDeployed:
+		err = sendSuccessProdDeploy(d, event.User, d.Production.URL)
+
+	case EventProdDeployFailed:
+		ts, err := sendFailedProdDeploy(d, event.Err)
+		if err == nil && event.Diagnostics != nil {
+			err = sendDiagnostics(d.Build.Project.Channel, ts, *event.Diagnostics)
+		}
+		return err
+
+	case EventProdRolledBack:
+		err = sendProdRollbackMessage(d, event.Err)
+	}
+
+	return
+}
+
+// webhookNotifier posts events as JSON to the "webhookURL" of the project.
+// If the project has a "webhookSecret", the request is signed the same
+// way we expect build notifications to be signed. The CI secret is never
+// used as whoever can check those signatures could also forge builds.
+type webhookNotifier struct{}
+
+type webhookPayload struct {
+	Event       EventType `json:"event"`
+	Deployment  string    `json:"deployment"`
+	Project     string    `json:"project"`
+	Image       string    `json:"image"`
+	Type        string    `json:"type"`
+	Target      string    `json:"target"`
+	Slug        string    `json:"slug"`
+	Environment string    `json:"environment,omitempty"`
+	URL         string    `json:"url,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Logs        string    `json:"logs,omitempty"`
+	Events      []string  `json:"events,omitempty"`
+}
+
+func (wn *webhookNotifier) Notify(event Event) (err error) {
+	d := event.Deployment
+	project := d.Build.Project
+
+	if project.WebhookURL == "" {
+		return errors.New("Project " + project.ID + " has no webhookURL")
+	}
+
+	payload := webhookPayload{
+		Event:      event.Type,
+		Deployment: d.ID,
+		Project:    project.ID,
+		Image:      d.Build.Image,
+		Type:       d.Build.Type,
+		Target:     d.Build.Target,
+		Slug:       d.Build.slug(),
+		URL:        d.URL,
+		User:       event.User,
+	}
+
+	switch event.Type {
+	case EventPromoted, EventPromoteFailed:
+		payload.Environment = event.Environment
+		p, _ := d.lastPromotion(event.Environment)
+		payload.URL = p.URL
+	case EventProdDeployed, EventProdDeployFailed:
+		payload.Environment = environmentProduction
+		payload.URL = d.Production.URL
+	}
+
+	if event.Err != nil {
+		payload.Error = event.Err.Error()
+	}
+
+	if event.Diagnostics != nil {
+		payload.Logs = event.Diagnostics.Logs
+		payload.Events = event.Diagnostics.Events
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	req, err := http.NewRequest("POST", project.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Add("Content-Type", "application/json")
+
+	if project.WebhookSecret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Add("X-CI-Timestamp", timestamp)
+		req.Header.Add("X-CI-Signature",
+			"sha256="+sign(project.WebhookSecret, "v1:"+timestamp+":"+string(body)))
+	}
+
+	netClient := &http.Client{
+		Timeout: time.Second * 10,
+		Transport: &http.Transport{
+			Dial: (&net.Dialer{
+				Timeout: 5 * time.Second,
+			}).Dial,
+			TLSHandshakeTimeout: 5 * time.Second,
+		},
+	}
+
+	resp, err := netClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		err = errors.New("Webhook for project " + project.ID + " returned " + resp.Status)
+	}
+
+	return
+}
@@ -1,95 +1,236 @@
 package main
 
 import (
-	"encoding/json"
 	"log"
+	"time"
 )
 
-type actionPayload struct {
-	Build         Build      `json:"build,omitempty"`
-	OwnerMessages []ownerMsg `json:"owner_messages,omitempty"`
+func (s *server) startProcessors() {
+	go s.buildProcessor()
+	go s.interactionProcessor()
+	go s.resumeBuilds()
+	go s.reaper()
 }
 
-type ownerMsg struct {
-	Owner   string `json:"owner,omitempty"`
-	Ts      string `json:"ts,omitempty"`
-	Channel string `json:"channel,omitempty"`
+// resumeBuilds() queues builds that had not finished deploying to QA
+// when the bot last stopped. Deploys past QA cannot be resumed safely
+// so they are marked as failed, which lets owners close them.
+func (s *server) resumeBuilds() {
+	deployments, err := s.Store.List()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, d := range deployments {
+		switch d.State {
+		case StateReceived, StateDeployingQA:
+			s.Builds <- d.ID
+		case StateDeployingProd, StatePromoting:
+			s.failInterrupted(d)
+		}
+	}
 }
 
-func (s *server) startProcessors() {
-	go s.buildProcessor()
-	go s.interactionProcessor()
+// failInterrupted() marks a deploy that was cut short by a restart as failed.
+// An interrupted rollback or promotion leaves the deployment where it was.
+func (s *server) failInterrupted(d Deployment) {
+	interrupted := "Interrupted by a restart of the bot"
+
+	d, err := s.Store.Update(d.ID, func(d *Deployment) error {
+		if d.rollingBack() {
+			d.Rollbacks = append(d.Rollbacks, rollback{Error: interrupted, At: time.Now()})
+			return d.Transition(StateSuperseded, "")
+		}
+		if d.State == StatePromoting {
+			next, _ := d.Build.Project.nextEnvironment(d.environment())
+			d.Promotions = append(d.Promotions, promotion{
+				Environment: next,
+				Error:       interrupted,
+				At:          time.Now(),
+			})
+			return d.Transition(d.promotedFrom(), "")
+		}
+		if d.State == StateDeployingProd {
+			d.Production.Error = interrupted
+		}
+		return d.Transition(StateFailed, "")
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	var errs []error
+
+	switch d.State {
+	case StateSuperseded:
+		// Its messages still show how its own deploy went
+		log.Println("Rollback to", d.ID, interrupted)
+	case StateFailed:
+		errs = setOwnerMessagesStatus(d, SlackAttachment{
+			Title:    "Failed",
+			Text:     interrupted,
+			Fallback: "Failed: " + interrupted,
+			Color:    "danger",
+		})
+	default:
+		// The promotion can be retried from the owner messages
+		errs = updateOwnerMessages(d)
+	}
+	if len(errs) > 0 {
+		log.Println(errs)
+	}
 }
 
 func (s *server) buildProcessor() {
-	for build := range s.Builds {
-		go func() {
+	for id := range s.Builds {
+		go s.processBuild(id)
+	}
+}
 
-			ts, attemptErr := sendAttemptDeployMessage(build)
-			if attemptErr != nil {
-				log.Println(attemptErr)
-				return
-			}
+// processBuild() deploys a received build to QA and lets
+// the owners and QA team know about it
+func (s *server) processBuild(id string) {
+
+	d, err := s.Store.Update(id, func(d *Deployment) error {
+		// A resumed build may have been interrupted while deploying
+		if d.State == StateDeployingQA {
+			return nil
+		}
+		return d.Transition(StateDeployingQA, "")
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
 
-			url, deployErr := deploy(build)
+	s.notify(Event{Type: EventBuildReceived, Deployment: d})
 
-			if deployErr != nil {
-				log.Println(deployErr)
+	var url string
+	deployer, deployErr := s.deployer(d.Build.Project)
+	if deployErr == nil {
+		url, deployErr = deployer.Deploy(d.Build)
+	}
 
-				failErr := sendFailedDeployMessage(build, ts, deployErr)
-				if failErr != nil {
-					log.Println(failErr)
-				}
-				return
-			}
+	if deployErr != nil {
+		log.Println(deployErr)
 
-			err := sendDeploySuccessMessage(build, ts, url)
-			if err != nil {
-				log.Println(err)
-				return
-			}
+		d, err = s.Store.Update(id, func(d *Deployment) error {
+			d.QAError = deployErr.Error()
+			return d.Transition(StateFailed, "")
+		})
+		if err != nil {
+			log.Println(err)
+			return
+		}
 
-			payload, errs := sendOwnerMessages(build, url)
-			if len(errs) > 0 {
-				log.Println(errs)
-				return
-			}
+		s.notify(Event{
+			Type:        EventQADeployFailed,
+			Deployment:  d,
+			Err:         deployErr,
+			Diagnostics: diagnose(deployer, d.Build, environmentQA),
+		})
+		return
+	}
 
-			errs = sendQaMessages(build, url, payload)
-			if len(errs) > 0 {
-				log.Println(errs)
-				return
-			}
+	d, err = s.Store.Update(id, func(d *Deployment) error {
+		d.URL = url
+		d.QADeployedAt = time.Now()
+		return d.Transition(StateQAReady, "")
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	s.notify(Event{Type: EventQADeploySucceeded, Deployment: d})
+
+	errs := s.closeReplaced(d)
+	if len(errs) > 0 {
+		log.Println(errs)
+	}
+
+	// The owner and QA messages are how the build gets approved
+	// so they are always sent on Slack
+	errs = sendOwnerMessages(&d)
+
+	_, err = s.Store.Update(id, func(stored *Deployment) error {
+		stored.OwnerMessages = d.OwnerMessages
+		return nil
+	})
+	if err != nil {
+		errs = append(errs, err)
+	}
 
-		}()
+	if len(errs) > 0 {
+		log.Println(errs)
+		return
+	}
+
+	errs = sendQaMessages(d)
+	if len(errs) > 0 {
+		log.Println(errs)
+		return
 	}
 }
 
 func (s *server) interactionProcessor() {
 	for interaction := range s.Interactions {
-		go func() {
+		go func(interaction SlackInteraction) {
+			d, err := s.getDeployment(interaction)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			if !s.authorize(interaction, d) {
+				return
+			}
+
 			switch interaction.CallbackID {
 			case "QA Response":
-				go handleQaResponse(interaction)
+				go s.handleQaResponse(interaction, d)
 			case "Deploy Decision":
-				go handleOwnerDeploy(interaction)
+				go s.handleOwnerDeploy(interaction, d)
+			case "Rollback":
+				go s.handleRollback(interaction, d)
+			case "Canary":
+				go s.handleCanary(interaction, d)
+			case "Keep Alive":
+				go s.handleKeepAlive(interaction, d)
 			}
-		}()
+		}(interaction)
 	}
 }
 
-func handleQaResponse(action SlackInteraction) {
-
-	user := action.User["id"]
-	channel := action.Channel["id"]
+// getDeployment() resolves the deployment an interaction refers to.
+// The button value is only ever an ID issued by the server.
+func (s *server) getDeployment(action SlackInteraction) (Deployment, error) {
+	return s.Store.Get(action.Actions[0].Value)
+}
 
-	var payload actionPayload
-	err := json.Unmarshal([]byte(action.Actions[0].Value), &payload)
-	if err != nil {
-		log.Println(err)
+// replyIfIllegal() lets the user know when what they clicked is not
+// allowed by the state of the deployment, the approval policy or another
+// deploy in progress. The messages of these errors are written for users.
+func replyIfIllegal(action SlackInteraction, err error) {
+	switch err.(type) {
+	case transitionError, approvalError, busyError:
+	default:
 		return
 	}
 
+	replyErr := sendEphemeral(action, err.Error())
+	if replyErr != nil {
+		log.Println(replyErr)
+	}
+}
+
+func (s *server) handleQaResponse(action SlackInteraction, d Deployment) {
+
+	user := action.User["id"]
+	channel := action.Channel["id"]
+
 	var newAttch SlackAttachment
 
 	switch action.Actions[0].Name {
@@ -107,6 +248,37 @@ func handleQaResponse(action SlackInteraction) {
 		}
 	}
 
+	approved := action.Actions[0].Name == "approve"
+
+	d, err := s.Store.Update(d.ID, func(d *Deployment) error {
+		switch d.State {
+		case StateQAReady, StateQAApproved, StateQARejected:
+		default:
+			to := StateQARejected
+			if approved {
+				to = StateQAApproved
+			}
+			return transitionError{From: d.State, To: to}
+		}
+
+		d.Verdicts = append(d.Verdicts, verdict{
+			User:     user,
+			Approved: approved,
+			At:       time.Now(),
+		})
+
+		// The approval policy decides if this verdict changes the state
+		if to := d.verdictState(); to != d.State {
+			return d.Transition(to, user)
+		}
+		return nil
+	})
+	if err != nil {
+		replyIfIllegal(action, err)
+		log.Println(err)
+		return
+	}
+
 	updtMsg := action.OrigMessage
 	updtMsg.Channel = channel
 	updtMsg.Ts = action.MessageTs
@@ -125,7 +297,7 @@ func handleQaResponse(action SlackInteraction) {
 	var newM SlackMessage
 	newM.Text = "<@" + user + "> has *" + newAttch.Title + "* this build"
 
-	for _, oM := range payload.OwnerMessages {
+	for _, oM := range d.OwnerMessages {
 		newM.ThreadTs = oM.Ts
 		newM.Channel = oM.Channel
 
@@ -136,18 +308,28 @@ func handleQaResponse(action SlackInteraction) {
 		}
 	}
 
+	errs := updateOwnerMessages(d)
+	if len(errs) > 0 {
+		log.Println(errs)
+	}
+
 	return
 }
 
-func handleOwnerDeploy(action SlackInteraction) {
+func (s *server) handleOwnerDeploy(action SlackInteraction, d Deployment) {
 
 	var errs []error
 
 	switch action.Actions[0].Name {
-	case "deploy":
-		errs = handleDeployToProd(action)
+	case "deploy", "promote":
+		next, ok := d.Build.Project.nextEnvironment(d.environment())
+		if !ok || next == environmentProduction {
+			errs = s.handleDeployToProd(action, d)
+		} else {
+			errs = s.handlePromote(action, d, next)
+		}
 	case "close":
-		errs = handleCloseDeployment(action)
+		errs = s.handleCloseDeployment(action, d)
 	}
 
 	if len(errs) > 0 {
@@ -158,24 +340,49 @@ func handleOwnerDeploy(action SlackInteraction) {
 	return
 }
 
-func handleDeployToProd(action SlackInteraction) (errs []error) {
+func (s *server) handleDeployToProd(action SlackInteraction, d Deployment) (errs []error) {
 
-	var payload actionPayload
-	err := json.Unmarshal([]byte(action.Actions[0].Value), &payload)
+	user := action.User["id"]
+
+	unlock, err := s.lockProduction(d)
 	if err != nil {
+		replyIfIllegal(action, err)
 		errs = append(errs, err)
 		return
 	}
+	defer unlock()
+
+	// Moving to deploying-prod first means a second click
+	// or a closed deployment cannot start another deploy
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		// A canary is only promoted from its own message
+		if d.State == StateCanary {
+			return transitionError{From: d.State, To: StateDeployingProd}
+		}
 
-	url, deployErr := deployToProd(payload.Build)
+		err := d.checkApprovals()
+		if err != nil {
+			return err
+		}
+		return d.Transition(StateDeployingProd, user)
+	})
+	if err != nil {
+		replyIfIllegal(action, err)
+		errs = append(errs, err)
+		return
+	}
 
-	if deployErr != nil {
-		errs = append(errs, deployErr)
+	started, canaryErrs := s.startCanary(d, user)
+	errs = append(errs, canaryErrs...)
+	if started {
+		return
+	}
 
-		failErr := sendFailedProdDeploy(payload, deployErr)
-		if failErr != nil {
-			errs = append(errs, failErr)
-		}
+	d, deployErrs := s.promote(d, user)
+	if len(deployErrs) > 0 {
+		errs = append(errs, deployErrs...)
+	}
+	if d.State != StateLive {
 		return
 	}
 
@@ -190,30 +397,34 @@ func handleDeployToProd(action SlackInteraction) (errs []error) {
 		},
 	)
 
-	for _, oM := range payload.OwnerMessages {
+	for _, oM := range d.OwnerMessages {
 		updtMsg.Channel = oM.Channel
 		updtMsg.Ts = oM.Ts
 
-		_, err = sendSlack(updtMsg)
+		_, err := sendSlack(updtMsg)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 	}
 
-	err = sendSuccessProdDeploy(payload, action.User["id"], url)
-	if err != nil {
-		errs = append(errs, err)
-	}
-
 	return
 }
 
-func handleCloseDeployment(action SlackInteraction) (errs []error) {
+func (s *server) handleCloseDeployment(action SlackInteraction, d Deployment) (errs []error) {
+
+	d, err := s.Store.Update(d.ID, func(d *Deployment) error {
+		// Only aborting a canary removes it from production
+		if d.State == StateCanary {
+			return transitionError{From: d.State, To: StateClosed}
+		}
 
-	var payload actionPayload
-	err := json.Unmarshal([]byte(action.Actions[0].Value), &payload)
+		d.ClosedBy = action.User["id"]
+		d.ClosedAt = time.Now()
+		return d.Transition(StateClosed, d.ClosedBy)
+	})
 	if err != nil {
+		replyIfIllegal(action, err)
 		errs = append(errs, err)
 		return
 	}
@@ -229,16 +440,18 @@ func handleCloseDeployment(action SlackInteraction) (errs []error) {
 		},
 	)
 
-	for _, oM := range payload.OwnerMessages {
+	for _, oM := range d.OwnerMessages {
 		updateMessage.Channel = oM.Channel
 		updateMessage.Ts = oM.Ts
 
-		_, err = sendSlack(updateMessage)
+		_, err := sendSlack(updateMessage)
 		if err != nil {
 			errs = append(errs, err)
 			continue
 		}
 	}
 
+	errs = append(errs, s.teardownIfUnused(d)...)
+
 	return
 }
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// productionLocks make sure only one deployment of a project
+// goes to production at a time
+type productionLocks struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newProductionLocks() *productionLocks {
+	return &productionLocks{locks: make(map[string]*sync.Mutex)}
+}
+
+// busyError is returned when another deployment of the project is
+// already going to production
+type busyError struct {
+	Project string
+}
+
+func (e busyError) Error() string {
+	return "Another deployment of " + e.Project +
+		" is going to production. Try again once it is done."
+}
+
+// lockProduction() reserves production of the project for the deployment.
+// It fails right away if another deployment holds it, including a
+// canary waiting to be promoted or aborted.
+func (s *server) lockProduction(d Deployment) (unlock func(), err error) {
+	project := d.Build.Project
+
+	s.ProdLocks.mu.Lock()
+	lock, ok := s.ProdLocks.locks[project.ID]
+	if !ok {
+		lock = &sync.Mutex{}
+		s.ProdLocks.locks[project.ID] = lock
+	}
+	s.ProdLocks.mu.Unlock()
+
+	if !lock.TryLock() {
+		return nil, busyError{Project: project.Name}
+	}
+
+	deployments, err := s.Store.List()
+	if err != nil {
+		lock.Unlock()
+		return nil, err
+	}
+
+	for _, other := range deployments {
+		if other.ID == d.ID || other.Build.Project.ID != project.ID {
+			continue
+		}
+
+		switch other.State {
+		case StateDeployingProd, StateCanary:
+			lock.Unlock()
+			return nil, busyError{Project: project.Name}
+		}
+	}
+
+	return lock.Unlock, nil
+}
+
+// liveDeployment() returns what is currently in production for the project
+func (s *server) liveDeployment(projectID string) (live Deployment, ok bool, err error) {
+	deployments, err := s.Store.List()
+	if err != nil {
+		return
+	}
+
+	for _, d := range deployments {
+		if d.Build.Project.ID == projectID && d.State == StateLive {
+			return d, true, nil
+		}
+	}
+
+	return
+}
+
+// previousProduction() returns what production is rolled back to if the
+// deploy of d fails: the live deployment, or else whatever image is
+// running, e.g. after an upgrade or when the last live deployment failed.
+// Production found that way has no ID as we have no record of it.
+func (s *server) previousProduction(deployer Deployer, d Deployment) (
+	previous Deployment, ok bool, err error) {
+
+	previous, ok, err = s.liveDeployment(d.Build.Project.ID)
+	if err != nil || ok {
+		return
+	}
+
+	status, err := deployer.Status(d.Build, environmentProduction)
+	if err != nil || !status.Exists || status.Image == "" || status.Image == d.Build.Image {
+		return
+	}
+
+	previous = Deployment{Build: d.Build}
+	previous.Build.Image = status.Image
+	return previous, true, nil
+}
+
+// productionHistory() returns the last deployments that went to
+// production for the project, the most recent first
+func (s *server) productionHistory(projectID string, limit int) (history []Deployment, err error) {
+	deployments, err := s.Store.List()
+	if err != nil {
+		return
+	}
+
+	for _, d := range deployments {
+		if d.Build.Project.ID != projectID {
+			continue
+		}
+
+		switch d.State {
+		case StateLive, StateSuperseded:
+			history = append(history, d)
+		}
+	}
+
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Production.DeployedAt.After(history[j].Production.DeployedAt)
+	})
+
+	if len(history) > limit {
+		history = history[:limit]
+	}
+
+	return
+}
+
+// rollingBack() reports whether production is being rolled back to the
+// deployment, i.e. it went to deploying-prod from superseded
+func (d Deployment) rollingBack() bool {
+	n := len(d.History)
+	return d.State == StateDeployingProd && n > 0 && d.History[n-1].From == StateSuperseded
+}
+
+// promote() deploys a deployment that is already in the deploying-prod
+// state to production and lets everyone know how it went.
+// Callers must hold the production lock of the project.
+// If the rollout fails, production is rolled back to what was live before.
+// A failed rollback to a superseded deployment leaves it superseded.
+func (s *server) promote(d Deployment, user string) (Deployment, []error) {
+	var errs []error
+
+	var url string
+	var previous Deployment
+	var hasPrevious bool
+	var err error
+
+	deployer, deployErr := s.deployer(d.Build.Project)
+	if deployErr == nil {
+		previous, hasPrevious, err = s.previousProduction(deployer, d)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		url, deployErr = deployer.Promote(d.Build, environmentProduction)
+	}
+
+	var diagnostics *Diagnostics
+	var rollbackErr error
+
+	if deployErr != nil {
+		// We need the logs of the broken pods before the rollback replaces them
+		diagnostics = diagnose(deployer, d.Build, environmentProduction)
+
+		if hasPrevious && deployer != nil {
+			_, rollbackErr = deployer.Promote(previous.Build, environmentProduction)
+		}
+	}
+
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		if d.rollingBack() {
+			attempt := rollback{By: user, At: time.Now()}
+			if deployErr != nil {
+				attempt.Error = deployErr.Error()
+			}
+			d.Rollbacks = append(d.Rollbacks, attempt)
+
+			// Its production deploy is still the one it was live with
+			if deployErr != nil {
+				return d.Transition(StateSuperseded, "")
+			}
+		}
+
+		d.Production = prodDeploy{
+			By:         user,
+			URL:        url,
+			DeployedAt: time.Now(),
+		}
+
+		if deployErr == nil {
+			if hasPrevious {
+				d.Production.Replaced = previous.ID
+			}
+			return d.Transition(StateLive, "")
+		}
+
+		d.Production.Error = deployErr.Error()
+		if hasPrevious {
+			d.Production.RolledBackTo = previous.ID
+			if rollbackErr != nil {
+				d.Production.RollbackError = rollbackErr.Error()
+			}
+		}
+		return d.Transition(StateFailed, "")
+	})
+	if err != nil {
+		errs = append(errs, err)
+		return d, errs
+	}
+
+	if deployErr != nil {
+		errs = append(errs, deployErr)
+		errs = append(errs, s.notify(Event{
+			Type:        EventProdDeployFailed,
+			Deployment:  d,
+			User:        user,
+			Err:         deployErr,
+			Diagnostics: diagnostics,
+		})...)
+
+		if hasPrevious {
+			errs = append(errs, s.notify(Event{
+				Type:       EventProdRolledBack,
+				Deployment: previous,
+				Err:        rollbackErr,
+			})...)
+		}
+
+		return d, errs
+	}
+
+	if previous.ID != "" {
+		_, err = s.Store.Update(previous.ID, func(previous *Deployment) error {
+			return previous.Transition(StateSuperseded, "")
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	errs = append(errs, s.notify(Event{
+		Type:       EventProdDeployed,
+		Deployment: d,
+		User:       user,
+	})...)
+
+	return d, errs
+}
+
+// handleRollback() redeploys a previous production deployment
+func (s *server) handleRollback(action SlackInteraction, d Deployment) {
+
+	user := action.User["id"]
+
+	unlock, err := s.lockProduction(d)
+	if err != nil {
+		replyIfIllegal(action, err)
+		log.Println(err)
+		return
+	}
+	defer unlock()
+
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		return d.Transition(StateDeployingProd, user)
+	})
+	if err != nil {
+		replyIfIllegal(action, err)
+		log.Println(err)
+		return
+	}
+
+	err = sendEphemeral(action, "Rolling back production of "+
+		d.Build.Project.Name+" to "+d.Build.Image)
+	if err != nil {
+		log.Println(err)
+	}
+
+	_, errs := s.promote(d, user)
+	if len(errs) > 0 {
+		log.Println(errs)
+	}
+}
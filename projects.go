@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -13,6 +14,48 @@ type Project struct {
 	Channel string
 	QA      []string
 	Owners  []string
+	Secret  string `json:"-"` // shared with the CI to sign build notifications
+
+	// The name of the Deployer to use, "kubernetes" if empty
+	Deployer string
+
+	// Namespaces for QA previews and production, "default" if empty.
+	// They can use {{project}}, {{type}}, {{target}} and {{environment}}
+	// e.g. "{{project}}-qa". The QA namespace and the namespaces of the
+	// environments between QA and production are created if missing.
+	QANamespace   string
+	ProdNamespace string
+
+	// The clusters for QA previews and production, see ClusterConfig
+	QACluster   string
+	ProdCluster string
+
+	// The environments from QA to production, see Environment
+	Environments []Environment
+
+	// How traffic reaches the project, see RoutingSpec
+	Routing RoutingSpec
+
+	// Production deploys go through a canary first if set, see CanarySpec
+	Canary CanarySpec
+
+	Container      ContainerSpec
+	RolloutTimeout time.Duration // how long to wait for pods to be ready
+	PreviewTTL     time.Duration // QA previews idle for longer are deleted
+
+	// Secrets and ConfigMaps for QA previews and production
+	QAConfig   RuntimeConfig
+	ProdConfig RuntimeConfig
+
+	// The names of the Notifiers to use, only "slack" if empty
+	Notifiers     []string
+	WebhookURL    string // for the "webhook" notifier
+	WebhookSecret string `json:"-"` // signs webhooks, never the same as Secret
+
+	// The number of QA approvals needed before deploying to production
+	// and whether a single rejection blocks it
+	RequiredApprovals int
+	BlockOnReject     bool
 }
 
 // This loads projectd defined in the config to the server
@@ -26,6 +69,18 @@ func (s *server) addProjects() {
 	}
 
 	for _, p := range projects {
+		if p.WebhookSecret != "" && p.WebhookSecret == p.Secret {
+			log.Println("Project " + p.ID + " uses its CI secret for webhooks, they will not be signed")
+			p.WebhookSecret = ""
+		}
+
+		// Otherwise every approved production deploy would fail
+		if p.Canary.Weight > 0 && !p.Routing.canCanary() {
+			log.Println("Project " + p.ID + " has a canary but " + p.Routing.provider() +
+				" routing cannot split traffic, it will be deployed without one")
+			p.Canary.Weight = 0
+		}
+
 		s.Projects[p.ID] = p
 	}
 }
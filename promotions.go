@@ -0,0 +1,147 @@
+package main
+
+import (
+	"time"
+)
+
+// handlePromote() deploys a build to an environment between QA and
+// production and offers to promote it to the one after
+func (s *server) handlePromote(action SlackInteraction, d Deployment,
+	environment string) (errs []error) {
+
+	user := action.User["id"]
+
+	// Like deploying to production, moving to promoting first means
+	// a second click cannot start another deploy
+	d, err := s.Store.Update(d.ID, func(d *Deployment) error {
+		err := d.checkApprovals()
+		if err != nil {
+			return err
+		}
+		return d.Transition(StatePromoting, user)
+	})
+	if err != nil {
+		replyIfIllegal(action, err)
+		errs = append(errs, err)
+		return
+	}
+
+	var url string
+	deployer, deployErr := s.deployer(d.Build.Project)
+	if deployErr == nil {
+		url, deployErr = deployer.Promote(d.Build, environment)
+	}
+
+	var diagnostics *Diagnostics
+	if deployErr != nil {
+		diagnostics = diagnose(deployer, d.Build, environment)
+	}
+
+	d, err = s.Store.Update(d.ID, func(d *Deployment) error {
+		p := promotion{
+			Environment: environment,
+			By:          user,
+			URL:         url,
+			At:          time.Now(),
+		}
+
+		// The build is still where it was so the promotion can be retried
+		if deployErr != nil {
+			p.Error = deployErr.Error()
+			d.Promotions = append(d.Promotions, p)
+			return d.Transition(d.promotedFrom(), "")
+		}
+
+		d.Promotions = append(d.Promotions, p)
+		return d.Transition(StatePromoted, "")
+	})
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	event := Event{
+		Type:        EventPromoted,
+		Deployment:  d,
+		User:        user,
+		Environment: environment,
+	}
+	if deployErr != nil {
+		errs = append(errs, deployErr)
+		event.Type = EventPromoteFailed
+		event.Err = deployErr
+		event.Diagnostics = diagnostics
+	}
+
+	errs = append(errs, s.notify(event)...)
+	errs = append(errs, updateOwnerMessages(d)...)
+
+	if deployErr == nil {
+		errs = append(errs, s.closePromoted(d, environment)...)
+	}
+
+	return
+}
+
+// closePromoted() closes the other deployments of the project that were
+// waiting in the environment, now that d replaced them there.
+// Promoting them further would skip the environment.
+func (s *server) closePromoted(d Deployment, environment string) (errs []error) {
+	deployments, err := s.Store.List()
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	project := d.Build.Project
+	reason := "Replaced in " + project.environmentName(environment) + " by a newer build"
+
+	for _, other := range deployments {
+		if other.ID == d.ID || other.Build.Project.ID != project.ID {
+			continue
+		}
+		if other.State != StatePromoted || other.environment() != environment {
+			continue
+		}
+
+		other, err := s.Store.Update(other.ID, func(other *Deployment) error {
+			other.ClosedAt = time.Now()
+			return other.Transition(StateClosed, "")
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		errs = append(errs, updateClosedOwnerMessages(other, reason)...)
+		errs = append(errs, s.teardownIfUnused(other)...)
+	}
+
+	return
+}
+
+// promotedFrom() is the state a promoting deployment was in before
+func (d Deployment) promotedFrom() State {
+	return d.History[len(d.History)-1].From
+}
+
+// environment() is the last environment the deployment was
+// successfully deployed to before production
+func (d Deployment) environment() string {
+	for i := len(d.Promotions) - 1; i >= 0; i-- {
+		if d.Promotions[i].Error == "" {
+			return d.Promotions[i].Environment
+		}
+	}
+	return environmentQA
+}
+
+// lastPromotion() returns the last deploy of the deployment to the environment
+func (d Deployment) lastPromotion(environment string) (p promotion, ok bool) {
+	for i := len(d.Promotions) - 1; i >= 0; i-- {
+		if d.Promotions[i].Environment == environment {
+			return d.Promotions[i], true
+		}
+	}
+	return
+}
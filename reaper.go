@@ -0,0 +1,204 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Preview is a QA preview found by a deployer
+type Preview struct {
+	Build     Build // only the project, type and target are set
+	CreatedAt time.Time
+}
+
+// PreviewLister is implemented by deployers that can list
+// the QA previews they are running
+type PreviewLister interface {
+	Previews() ([]Preview, error)
+}
+
+// reaperInterval is how often we look for idle previews.
+// It is "reaperInterval" in the config and defaults to 10 minutes.
+func reaperInterval() time.Duration {
+	interval := viper.GetDuration("reaperInterval")
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	return interval
+}
+
+// reaperWarning is how long before deleting a preview we warn the
+// project channel. It is "reaperWarning" in the config and defaults to 1 hour.
+func reaperWarning() time.Duration {
+	warning := viper.GetDuration("reaperWarning")
+	if warning <= 0 {
+		warning = time.Hour
+	}
+	return warning
+}
+
+// reaper() periodically deletes QA previews that have been idle for
+// longer than the "previewTTL" of their project.
+// Projects without a TTL keep their previews until they are torn down.
+func (s *server) reaper() {
+	for range time.Tick(reaperInterval()) {
+		s.reap()
+	}
+}
+
+func (s *server) reap() {
+	for name, deployer := range s.Deployers {
+		lister, ok := deployer.(PreviewLister)
+		if !ok {
+			continue
+		}
+
+		previews, err := lister.Previews()
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		for _, preview := range previews {
+			project, ok := s.Projects[preview.Build.Project.ID]
+			if !ok || project.PreviewTTL <= 0 {
+				continue
+			}
+
+			// Leave previews of projects using another deployer alone
+			projectDeployer := project.Deployer
+			if projectDeployer == "" {
+				projectDeployer = "kubernetes"
+			}
+			if projectDeployer != name {
+				continue
+			}
+
+			preview.Build.Project = project
+			s.reapPreview(preview)
+		}
+	}
+}
+
+// reapPreview() warns about or deletes a single preview.
+// A preview is active from its last build or the last time someone
+// asked to keep it alive.
+func (s *server) reapPreview(preview Preview) {
+	ttl := preview.Build.Project.PreviewTTL
+	warning := reaperWarning()
+
+	latest, hasLatest, err := s.latestDeployment(preview.Build)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	lastActive := preview.CreatedAt
+	if hasLatest {
+		if latest.ReceivedAt.After(lastActive) {
+			lastActive = latest.ReceivedAt
+		}
+		if latest.KeepAliveAt.After(lastActive) {
+			lastActive = latest.KeepAliveAt
+		}
+	}
+
+	now := time.Now()
+	expires := lastActive.Add(ttl)
+
+	if now.Before(expires.Add(-warning)) {
+		return
+	}
+
+	// Without a deployment there is nobody to ask so we only wait for the TTL
+	if !hasLatest {
+		if now.After(expires) {
+			s.teardownTarget(preview.Build, idleReason(ttl))
+		}
+		return
+	}
+
+	warned := latest.ReapWarnedAt.After(lastActive)
+
+	if !warned {
+		_, err = s.Store.Update(latest.ID, func(d *Deployment) error {
+			d.ReapWarnedAt = now
+			return nil
+		})
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		err = sendReapWarning(latest, warning)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	// Always give people the full warning period, even if we were not
+	// running when the warning should have gone out
+	if now.After(expires) && now.After(latest.ReapWarnedAt.Add(warning)) {
+		s.teardownTarget(preview.Build, idleReason(ttl))
+	}
+}
+
+// idleReason() is why we tell people an idle preview was deleted
+func idleReason(ttl time.Duration) string {
+	return "Idle for longer than " + ttl.String()
+}
+
+// latestDeployment() returns the most recent deployment of the target of the build
+func (s *server) latestDeployment(build Build) (latest Deployment, ok bool, err error) {
+	deployments, err := s.Store.List()
+	if err != nil {
+		return
+	}
+
+	for _, d := range deployments {
+		if !sameTarget(d.Build, build) {
+			continue
+		}
+
+		if !ok || d.ReceivedAt.After(latest.ReceivedAt) {
+			latest, ok = d, true
+		}
+	}
+
+	return
+}
+
+// handleKeepAlive() resets the idle timer of a preview
+func (s *server) handleKeepAlive(action SlackInteraction, d Deployment) {
+
+	user := action.User["id"]
+
+	_, err := s.Store.Update(d.ID, func(d *Deployment) error {
+		d.KeepAliveAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	updtMsg := action.OrigMessage
+	updtMsg.Channel = action.Channel["id"]
+	updtMsg.Ts = action.MessageTs
+	updtMsg.Update = true
+	updtMsg.Attachments = []SlackAttachment{
+		SlackAttachment{
+			Title:    "Kept alive by <@" + user + ">",
+			Fallback: "Kept alive",
+			Color:    "good",
+		},
+	}
+
+	_, err = sendSlack(updtMsg)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/viper"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// These waiting reasons mean the pods will not become ready
+// without someone fixing the build or the config
+var fatalPodReasons = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+}
+
+// revisionAnnotation is set by the Deployment controller on a Deployment
+// and its ReplicaSets. The ReplicaSet of the current revision is the new one.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+// rolloutError explains why a Deployment did not become ready
+type rolloutError struct {
+	Deployment string
+	Pod        string
+	Reason     string
+	Message    string
+}
+
+func (e rolloutError) Error() string {
+	msg := "Deployment " + e.Deployment + " did not become ready: " + e.Reason
+	if e.Pod != "" {
+		msg += " (pod " + e.Pod + ")"
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	return msg
+}
+
+// rolloutTimeout() is how long we wait for the project to become ready.
+// It is "rolloutTimeout" on the project, or in the config, or 5 minutes.
+func rolloutTimeout(project Project) time.Duration {
+	if project.RolloutTimeout > 0 {
+		return project.RolloutTimeout
+	}
+
+	timeout := viper.GetDuration("rolloutTimeout")
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	return timeout
+}
+
+// waitForRollout() waits until every replica of the Deployment runs
+// the latest spec and is available. It gives up early if the pods
+// are stuck for a reason that will not fix itself.
+func waitForRollout(clientset *kubernetes.Clientset, namespace, name string,
+	timeout time.Duration) (err error) {
+
+	depClient := clientset.AppsV1().Deployments(namespace)
+
+	var deployment *appsv1.Deployment
+	err = wait.PollImmediate(2*time.Second, timeout, func() (bool, error) {
+		deployment, err = depClient.Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+
+		if rolloutComplete(deployment) {
+			return true, nil
+		}
+
+		for _, c := range deployment.Status.Conditions {
+			if c.Type == appsv1.DeploymentProgressing && c.Reason == "ProgressDeadlineExceeded" {
+				return false, rolloutError{
+					Deployment: name,
+					Reason:     c.Reason,
+					Message:    c.Message,
+				}
+			}
+		}
+
+		failure, err := podFailure(clientset, deployment)
+		if err != nil {
+			return false, err
+		}
+		if failure != nil && fatalPodReasons[failure.Reason] {
+			return false, *failure
+		}
+
+		return false, nil
+	})
+
+	if err == wait.ErrWaitTimeout {
+		timeoutErr := rolloutError{
+			Deployment: name,
+			Reason:     "Timeout",
+			Message:    "not ready after " + timeout.String(),
+		}
+
+		if deployment != nil {
+			failure, _ := podFailure(clientset, deployment)
+			if failure != nil {
+				timeoutErr.Pod = failure.Pod
+				timeoutErr.Reason = failure.Reason
+				timeoutErr.Message = failure.Message
+			}
+		}
+
+		err = timeoutErr
+	}
+
+	return
+}
+
+// rolloutComplete() reports whether all replicas are updated and available
+func rolloutComplete(deployment *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+
+	status := deployment.Status
+	return status.ObservedGeneration >= deployment.Generation &&
+		status.UpdatedReplicas == replicas &&
+		status.Replicas == replicas &&
+		status.AvailableReplicas == replicas
+}
+
+// podFailure() looks for a pod of the Deployment that is stuck waiting,
+// keeps crashing or cannot be scheduled. It returns nil if there is none.
+// Only pods of the new ReplicaSet are looked at: the previous version
+// may have failing pods of its own that are being replaced.
+func podFailure(clientset *kubernetes.Clientset,
+	deployment *appsv1.Deployment) (*rolloutError, error) {
+
+	pods, err := newPods(clientset, deployment)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if w := cs.State.Waiting; w != nil && w.Reason != "ContainerCreating" {
+				return &rolloutError{
+					Deployment: deployment.Name,
+					Pod:        pod.Name,
+					Reason:     w.Reason,
+					Message:    w.Message,
+				}, nil
+			}
+
+			if t := cs.LastTerminationState.Terminated; t != nil && !cs.Ready {
+				return &rolloutError{
+					Deployment: deployment.Name,
+					Pod:        pod.Name,
+					Reason:     t.Reason,
+					Message:    fmt.Sprintf("exited with code %d", t.ExitCode),
+				}, nil
+			}
+		}
+
+		if pod.Status.Phase == apiv1.PodPending {
+			for _, c := range pod.Status.Conditions {
+				if c.Type == apiv1.PodScheduled && c.Status == apiv1.ConditionFalse {
+					return &rolloutError{
+						Deployment: deployment.Name,
+						Pod:        pod.Name,
+						Reason:     c.Reason,
+						Message:    c.Message,
+					}, nil
+				}
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// newPods() returns the pods of the new ReplicaSet of the Deployment,
+// none if it was not created yet
+func newPods(clientset *kubernetes.Clientset,
+	deployment *appsv1.Deployment) ([]apiv1.Pod, error) {
+
+	hash, err := newPodTemplateHash(clientset, deployment)
+	if err != nil || hash == "" {
+		return nil, err
+	}
+
+	set := labels.Set{}
+	for k, v := range deployment.Spec.Selector.MatchLabels {
+		set[k] = v
+	}
+	set[appsv1.DefaultDeploymentUniqueLabelKey] = hash
+
+	pods, err := clientset.CoreV1().Pods(deployment.Namespace).List(
+		metav1.ListOptions{LabelSelector: set.AsSelector().String()},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return pods.Items, nil
+}
+
+// newPodTemplateHash() returns the pod-template-hash of the ReplicaSet
+// of the current revision of the Deployment, "" if it was not created yet
+func newPodTemplateHash(clientset *kubernetes.Clientset,
+	deployment *appsv1.Deployment) (string, error) {
+
+	revision := deployment.Annotations[revisionAnnotation]
+	if revision == "" {
+		return "", nil
+	}
+
+	selector := labels.SelectorFromSet(deployment.Spec.Selector.MatchLabels).String()
+	sets, err := clientset.AppsV1().ReplicaSets(deployment.Namespace).List(
+		metav1.ListOptions{LabelSelector: selector},
+	)
+	if err != nil {
+		return "", err
+	}
+
+	for _, rs := range sets.Items {
+		if !metav1.IsControlledBy(&rs, deployment) {
+			continue
+		}
+		if rs.Annotations[revisionAnnotation] == revision {
+			return rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey], nil
+		}
+	}
+
+	return "", nil
+}
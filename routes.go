@@ -7,7 +7,9 @@ import (
 func (s *server) addRoutes() {
 	r := chi.NewRouter()
 	r.NotFound(s.Handlers.Use("404")) // A route for 404s
-	r.Post("/build-complete", s.Handlers.Use("BuildComplete"))
-	r.Post("/slack-interactions", s.Handlers.Use("SlackInteractions"))
+	r.With(s.verifyBuildSignature).Post("/build-complete", s.Handlers.Use("BuildComplete"))
+	r.With(s.verifyBuildSignature).Post("/build-deleted", s.Handlers.Use("BuildDeleted"))
+	r.With(s.verifySlackSignature).Post("/slack-interactions", s.Handlers.Use("SlackInteractions"))
+	r.With(s.verifySlackSignature).Post("/slack-commands", s.Handlers.Use("SlackCommands"))
 	s.Router = r
 }
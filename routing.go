@@ -0,0 +1,244 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// RoutingSpec is how traffic reaches a project. It is set with "routing"
+// on the project and the provider is one of:
+//
+// annotation: a getambassador.io/config annotation on the Service (default)
+// mapping: an Ambassador Mapping resource
+// ingress: a networking.k8s.io/v1 Ingress
+// httproute: a Gateway API HTTPRoute
+type RoutingSpec struct {
+	Provider string
+
+	IngressClass string // for ingress
+	TLSSecret    string // for ingress, enables TLS for the host
+
+	Gateway          string // for httproute, the Gateway to attach to
+	GatewayNamespace string
+}
+
+const (
+	routingAnnotation = "annotation"
+	routingMapping    = "mapping"
+	routingIngress    = "ingress"
+	routingHTTPRoute  = "httproute"
+)
+
+func (rs RoutingSpec) provider() string {
+	if rs.Provider == "" {
+		return routingAnnotation
+	}
+	return rs.Provider
+}
+
+// resource() is the API resource the provider creates.
+// The annotation provider has none.
+func (rs RoutingSpec) resource() (gvr schema.GroupVersionResource, ok bool) {
+	switch rs.provider() {
+	case routingMapping:
+		return schema.GroupVersionResource{
+			Group: "getambassador.io", Version: "v2", Resource: "mappings",
+		}, true
+	case routingIngress:
+		return schema.GroupVersionResource{
+			Group: "networking.k8s.io", Version: "v1", Resource: "ingresses",
+		}, true
+	case routingHTTPRoute:
+		return schema.GroupVersionResource{
+			Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes",
+		}, true
+	}
+	return
+}
+
+// canCanary() reports whether the provider can split traffic with a canary
+func (rs RoutingSpec) canCanary() bool {
+	switch rs.provider() {
+	case routingMapping, routingIngress:
+		return true
+	}
+	return false
+}
+
+// route() builds the routing object for the target.
+// It returns nil for the annotation provider.
+func (rs RoutingSpec) route(t deployTarget) (*unstructured.Unstructured, error) {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	host := u.Hostname()
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	port := int64(t.Container.servicePort())
+
+	if t.Weight > 0 && !rs.canCanary() {
+		return nil, errors.New("Canaries need mapping or ingress routing, not " + rs.provider())
+	}
+
+	labels := make(map[string]interface{})
+	for k, v := range t.Labels {
+		labels[k] = v
+	}
+
+	metadata := map[string]interface{}{
+		"name":      t.ID,
+		"namespace": t.Namespace,
+		"labels":    labels,
+	}
+
+	var obj map[string]interface{}
+
+	switch rs.provider() {
+	case routingAnnotation:
+		return nil, nil
+
+	case routingMapping:
+		obj = map[string]interface{}{
+			"apiVersion": "getambassador.io/v2",
+			"kind":       "Mapping",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"host":    host,
+				"prefix":  path,
+				"service": t.ID + "." + t.Namespace + ":" + strconv.FormatInt(port, 10),
+			},
+		}
+
+		// Ambassador sends the rest of the traffic to the other
+		// Mapping with the same host and prefix
+		if t.Weight > 0 {
+			obj["spec"].(map[string]interface{})["weight"] = int64(t.Weight)
+		}
+
+	case routingIngress:
+		spec := map[string]interface{}{
+			"rules": []interface{}{
+				map[string]interface{}{
+					"host": host,
+					"http": map[string]interface{}{
+						"paths": []interface{}{
+							map[string]interface{}{
+								"path":     path,
+								"pathType": "Prefix",
+								"backend": map[string]interface{}{
+									"service": map[string]interface{}{
+										"name": t.ID,
+										"port": map[string]interface{}{
+											"number": port,
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+
+		if rs.IngressClass != "" {
+			spec["ingressClassName"] = rs.IngressClass
+		}
+
+		if rs.TLSSecret != "" {
+			spec["tls"] = []interface{}{
+				map[string]interface{}{
+					"hosts":      []interface{}{host},
+					"secretName": rs.TLSSecret,
+				},
+			}
+		}
+
+		obj = map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "Ingress",
+			"metadata":   metadata,
+			"spec":       spec,
+		}
+
+		// The NGINX ingress controller sends the rest of the traffic
+		// to the other Ingress with the same host
+		if t.Weight > 0 {
+			metadata["annotations"] = map[string]interface{}{
+				"nginx.ingress.kubernetes.io/canary":        "true",
+				"nginx.ingress.kubernetes.io/canary-weight": strconv.Itoa(t.Weight),
+			}
+		}
+
+	case routingHTTPRoute:
+		if rs.Gateway == "" {
+			return nil, errors.New("httproute routing needs a gateway")
+		}
+
+		parentRef := map[string]interface{}{
+			"name": rs.Gateway,
+		}
+		if rs.GatewayNamespace != "" {
+			parentRef["namespace"] = rs.GatewayNamespace
+		}
+
+		obj = map[string]interface{}{
+			"apiVersion": "gateway.networking.k8s.io/v1",
+			"kind":       "HTTPRoute",
+			"metadata":   metadata,
+			"spec": map[string]interface{}{
+				"parentRefs": []interface{}{parentRef},
+				"hostnames":  []interface{}{host},
+				"rules": []interface{}{
+					map[string]interface{}{
+						"matches": []interface{}{
+							map[string]interface{}{
+								"path": map[string]interface{}{
+									"type":  "PathPrefix",
+									"value": path,
+								},
+							},
+						},
+						"backendRefs": []interface{}{
+							map[string]interface{}{
+								"name": t.ID,
+								"port": port,
+							},
+						},
+					},
+				},
+			},
+		}
+
+	default:
+		return nil, errors.New("Unknown routing provider " + rs.Provider)
+	}
+
+	return &unstructured.Unstructured{Object: obj}, nil
+}
+
+// annotations() are the Service annotations for the provider.
+// Only the legacy annotation provider has any.
+func (rs RoutingSpec) annotations(t deployTarget) map[string]string {
+	if rs.provider() != routingAnnotation || t.Weight > 0 {
+		return nil
+	}
+
+	return map[string]string{
+		"getambassador.io/config": ` |
+			      ---
+			      apiVersion: ambassador/v0
+			      kind:  Mapping
+			      name:  ` + t.ID + `
+			      host: ` + t.URL + `
+			      service: ` + t.ID + "." + t.Namespace + ":" +
+			strconv.Itoa(int(t.Container.servicePort())),
+	}
+}
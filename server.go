@@ -8,25 +8,60 @@ type server struct {
 	Router       http.Handler
 	Handlers     Handlers
 	Projects     map[string]Project
-	Builds       chan Build
+	Builds       chan string // IDs of deployments in the Store
 	Interactions chan SlackInteraction
+	Store        Store
+	Deployers    Deployers
+	Notifiers    Notifiers
+	ProdLocks    *productionLocks
 }
 
 func NewServer() (*server, error) {
 	s := &server{}
 
-	s.Builds = make(chan Build, 5)
+	s.Builds = make(chan string, 5)
 	s.Interactions = make(chan SlackInteraction, 5)
 	s.Projects = make(map[string]Project)
 	s.Handlers = make(map[string]func() http.HandlerFunc)
-	s.load()
+	s.Deployers = make(Deployers)
+	s.Notifiers = make(Notifiers)
+	s.ProdLocks = newProductionLocks()
+
+	// The store looks up the projects of the deployments it loads
+	// so they must all be there before it is opened
+	s.addProjects()
+
+	store, err := newStore(s.project)
+	if err != nil {
+		return nil, err
+	}
+	s.Store = store
+
+	err = s.load()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
 
 	return s, nil
 }
 
-func (s *server) load() {
-	s.startProcessors() // to read from the channels
-	s.addProjects()     // all our projects
+func (s *server) load() (err error) {
+	err = s.addDeployers() // where builds can be deployed
+	if err != nil {
+		return
+	}
+
+	s.addNotifiers()    // who we tell about deployments
 	s.addHandlers()     // the handlers for our routes
 	s.addRoutes()       // Setting up the routes
+	s.startProcessors() // to read from the channels, last as they use everything above
+
+	return
+}
+
+// project() looks up a project in the config
+func (s *server) project(id string) (Project, bool) {
+	p, ok := s.Projects[id]
+	return p, ok
 }
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// audit logs security related events with a common prefix
+// so they are easy to find
+func audit(format string, v ...interface{}) {
+	log.Printf("audit: "+format, v...)
+}
+
+// signatureWindow is how far a signed timestamp may drift from our clock.
+// It can be set with "signatureWindow" in the config and defaults to 5 minutes.
+func signatureWindow() time.Duration {
+	window := viper.GetDuration("signatureWindow")
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	return window
+}
+
+// checkTimestamp() parses a unix timestamp and makes sure it is
+// within the window.
+func checkTimestamp(timestamp string, window time.Duration) (t time.Time, err error) {
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		err = errors.New("invalid timestamp")
+		return
+	}
+
+	t = time.Unix(secs, 0)
+	drift := time.Since(t)
+	if drift < 0 {
+		drift = -drift
+	}
+
+	if drift > window {
+		err = errors.New("stale timestamp")
+	}
+
+	return
+}
+
+// sign() returns the hex encoded HMAC-SHA256 of the message
+func sign(secret, message string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// readBody() reads the request body and puts it back
+// so the handlers can still parse it
+func readBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// verifyBuildSignature checks that a build notification was signed with
+// the secret of the project it is for.
+// The CI sends the unix time in X-CI-Timestamp and "sha256=" followed by
+// the HMAC of "v1:<timestamp>:<body>" in X-CI-Signature.
+func (s *server) verifyBuildSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		unauthorized := func(reason string) {
+			audit("rejected %s from %s: %s", r.URL.Path, r.RemoteAddr, reason)
+			http.Error(w, "Unauthorized", 401)
+		}
+
+		body, err := readBody(w, r)
+		if err != nil {
+			unauthorized(err.Error())
+			return
+		}
+
+		projectName := r.FormValue("project")
+		project, ok := s.Projects[projectName]
+		if !ok {
+			unauthorized("unknown project " + projectName)
+			return
+		}
+
+		if project.Secret == "" {
+			unauthorized("project " + projectName + " has no secret")
+			return
+		}
+
+		timestamp := r.Header.Get("X-CI-Timestamp")
+		window := signatureWindow()
+		t, err := checkTimestamp(timestamp, window)
+		if err != nil {
+			unauthorized(err.Error())
+			return
+		}
+
+		signature := r.Header.Get("X-CI-Signature")
+		expected := "sha256=" + sign(project.Secret, "v1:"+timestamp+":"+string(body))
+		if !hmac.Equal([]byte(signature), []byte(expected)) {
+			unauthorized("invalid signature for project " + projectName)
+			return
+		}
+
+		// Accepted signatures are kept in the Store so a restart
+		// does not let them be replayed
+		seen, err := s.Store.Seen(signature, t.Add(window))
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Error encountered", 500)
+			return
+		}
+		if seen {
+			unauthorized("replayed signature for project " + projectName)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// verifySlackSignature checks the X-Slack-Signature header against the
+// signing secrets in "slackSigningSecrets". Two secrets can be active at
+// the same time so that the secret can be rotated without downtime.
+func (s *server) verifySlackSignature(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		unauthorized := func(reason string) {
+			audit("rejected %s from %s: %s", r.URL.Path, r.RemoteAddr, reason)
+			http.Error(w, "Unauthorized", 401)
+		}
+
+		body, err := readBody(w, r)
+		if err != nil {
+			unauthorized(err.Error())
+			return
+		}
+
+		secrets := viper.GetStringSlice("slackSigningSecrets")
+		if len(secrets) == 0 {
+			unauthorized("no slack signing secrets configured")
+			return
+		}
+
+		if len(secrets) > 2 {
+			log.Println("only the first 2 slack signing secrets are used")
+			secrets = secrets[:2]
+		}
+
+		timestamp := r.Header.Get("X-Slack-Request-Timestamp")
+		_, err = checkTimestamp(timestamp, 5*time.Minute)
+		if err != nil {
+			unauthorized(err.Error())
+			return
+		}
+
+		signature := []byte(r.Header.Get("X-Slack-Signature"))
+		message := "v0:" + timestamp + ":" + string(body)
+
+		for _, secret := range secrets {
+			if hmac.Equal(signature, []byte("v0="+sign(secret, message))) {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		unauthorized("invalid slack signature")
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const testSecret = "s3cret"
+
+func newSignatureServer(t *testing.T, path string) *server {
+	store, err := newBoltStore(path, func(id string) (Project, bool) {
+		return Project{}, false
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	return &server{
+		Store: store,
+		Projects: map[string]Project{
+			"web": {ID: "web", Name: "web", Secret: testSecret},
+		},
+	}
+}
+
+// buildRequest() is a build notification signed with secret at ts
+func buildRequest(secret string, ts time.Time) *http.Request {
+	body := url.Values{
+		"project": {"web"},
+		"image":   {"registry/web:1"},
+		"target":  {"master"},
+		"type":    {"branch"},
+	}.Encode()
+
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+
+	r := httptest.NewRequest("POST", "/build", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-CI-Timestamp", timestamp)
+	r.Header.Set("X-CI-Signature", "sha256="+sign(secret, "v1:"+timestamp+":"+body))
+	return r
+}
+
+func TestVerifyBuildSignature(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		want int
+	}{
+		{"valid", buildRequest(testSecret, now), 200},
+		{"bad signature", buildRequest("not-the-secret", now), 401},
+		{"stale", buildRequest(testSecret, now.Add(-10*time.Minute)), 401},
+		{"future", buildRequest(testSecret, now.Add(10*time.Minute)), 401},
+	}
+
+	for _, test := range tests {
+		s := newSignatureServer(t, filepath.Join(t.TempDir(), "ci-bot.db"))
+
+		w := httptest.NewRecorder()
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		s.verifyBuildSignature(ok).ServeHTTP(w, test.req)
+
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
+
+func TestVerifyBuildSignatureReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ci-bot.db")
+	ts := time.Now()
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	send := func(s *server) int {
+		w := httptest.NewRecorder()
+		s.verifyBuildSignature(ok).ServeHTTP(w, buildRequest(testSecret, ts))
+		return w.Code
+	}
+
+	s := newSignatureServer(t, path)
+	if code := send(s); code != 200 {
+		t.Fatalf("first request: got %d, want 200", code)
+	}
+	if code := send(s); code != 401 {
+		t.Errorf("replayed request: got %d, want 401", code)
+	}
+
+	// The signatures we accepted survive a restart
+	s.Store.Close()
+	s = newSignatureServer(t, path)
+	if code := send(s); code != 401 {
+		t.Errorf("replayed request after a restart: got %d, want 401", code)
+	}
+}
+
+// slackRequest() is a Slack interaction signed with secret at ts
+func slackRequest(secret string, ts time.Time) *http.Request {
+	body := url.Values{"payload": {`{"type":"interactive_message"}`}}.Encode()
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+
+	r := httptest.NewRequest("POST", "/slack", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+sign(secret, "v0:"+timestamp+":"+body))
+	return r
+}
+
+func TestVerifySlackSignature(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		secrets []string
+		req     *http.Request
+		want    int
+	}{
+		{"old secret", []string{"old", "new"}, slackRequest("old", now), 200},
+		{"new secret", []string{"old", "new"}, slackRequest("new", now), 200},
+		{"unknown secret", []string{"old", "new"}, slackRequest("other", now), 401},
+		{"stale", []string{"old", "new"}, slackRequest("old", now.Add(-6*time.Minute)), 401},
+		{"third secret ignored", []string{"old", "new", "extra"}, slackRequest("extra", now), 401},
+		{"no secrets", nil, slackRequest("old", now), 401},
+	}
+
+	t.Cleanup(func() { viper.Set("slackSigningSecrets", nil) })
+
+	for _, test := range tests {
+		viper.Set("slackSigningSecrets", test.secrets)
+
+		s := &server{}
+		w := httptest.NewRecorder()
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		s.verifySlackSignature(ok).ServeHTTP(w, test.req)
+
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.want)
+		}
+	}
+}
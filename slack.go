@@ -13,18 +13,20 @@ import (
 )
 
 type SlackMessage struct {
-	Channel     string            `json:"channel,omitempty"`
-	Text        string            `json:"text,omitempty"`
-	Attachments []SlackAttachment `json:"attachments,omitempty"`
-	User        string            `json:"user,omitempty"`
-	Ts          string            `json:"ts,omitempty"`
-	ThreadTs    string            `json:"thread_ts,omitempty"`
-	Update      bool              `json:"-"`
-	Ephemeral   bool              `json:"-"`
+	Channel      string            `json:"channel,omitempty"`
+	Text         string            `json:"text,omitempty"`
+	Attachments  []SlackAttachment `json:"attachments,omitempty"`
+	User         string            `json:"user,omitempty"`
+	Ts           string            `json:"ts,omitempty"`
+	ThreadTs     string            `json:"thread_ts,omitempty"`
+	ResponseType string            `json:"response_type,omitempty"` // for slash commands
+	Update       bool              `json:"-"`
+	Ephemeral    bool              `json:"-"`
 }
 
 type SlackAttachment struct {
 	Title      string        `json:"title,omitempty"`
+	Text       string        `json:"text,omitempty"`
 	Fallback   string        `json:"fallback,omitempty"`
 	Fields     []SlackField  `json:"fields,omitempty"`
 	CallbackID string        `json:"callback_id,omitempty"`
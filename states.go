@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// State is where a deployment is in its life from QA to production
+type State string
+
+const (
+	StateReceived      State = "received"
+	StateDeployingQA   State = "deploying-qa"
+	StateQAReady       State = "qa-ready"
+	StateQAApproved    State = "qa-approved"
+	StateQARejected    State = "qa-rejected"
+	StatePromoting     State = "promoting" // to an environment between QA and production
+	StatePromoted      State = "promoted"  // in an environment between QA and production
+	StateDeployingProd State = "deploying-prod"
+	StateCanary        State = "canary" // getting part of the production traffic
+	StateLive          State = "live"
+	StateSuperseded    State = "superseded" // was live until a newer build replaced it
+	StateClosed        State = "closed"
+	StateFailed        State = "failed"
+)
+
+// transitions lists the states a deployment can move to from each state.
+// Anything not listed here is rejected.
+var transitions = map[State][]State{
+	StateReceived:      {StateDeployingQA, StateClosed},
+	StateDeployingQA:   {StateQAReady, StateFailed},
+	StateQAReady:       {StateQAApproved, StateQARejected, StatePromoting, StateDeployingProd, StateClosed},
+	StateQAApproved:    {StateQARejected, StatePromoting, StateDeployingProd, StateClosed},
+	StateQARejected:    {StateQAApproved, StateClosed},
+	StatePromoting:     {StatePromoted, StateQAReady, StateQAApproved, StateFailed}, // back where it was if it fails
+	StatePromoted:      {StatePromoting, StateDeployingProd, StateClosed},
+	StateDeployingProd: {StateLive, StateCanary, StateFailed, StateSuperseded},
+	StateCanary:        {StateDeployingProd, StateClosed}, // only closed by aborting it
+	StateLive:          {StateSuperseded},
+	StateSuperseded:    {StateDeployingProd}, // rolling back to it
+	StateClosed:        {},
+	StateFailed:        {StateClosed},
+}
+
+// transition is a change of state we keep in the deployment's history
+type transition struct {
+	From State     `json:"from"`
+	To   State     `json:"to"`
+	By   string    `json:"by,omitempty"`
+	At   time.Time `json:"at"`
+}
+
+// transitionError is returned for a transition that is not allowed
+type transitionError struct {
+	From State
+	To   State
+}
+
+func (e transitionError) Error() string {
+	return fmt.Sprintf("This deployment is *%s* and cannot be moved to *%s*", e.From, e.To)
+}
+
+// CanTransition() reports whether the deployment can move to the state
+func (d Deployment) CanTransition(to State) bool {
+	for _, allowed := range transitions[d.State] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Transition() moves the deployment to a new state and records who did it.
+// by is empty when the bot itself makes the change.
+func (d *Deployment) Transition(to State, by string) error {
+	if !d.CanTransition(to) {
+		return transitionError{From: d.State, To: to}
+	}
+
+	d.History = append(d.History, transition{
+		From: d.State,
+		To:   to,
+		By:   by,
+		At:   time.Now(),
+	})
+	d.State = to
+
+	return nil
+}
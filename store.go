@@ -0,0 +1,279 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+)
+
+var errDeploymentNotFound = errors.New("deployment not found")
+
+// Store persists deployments so that nothing is lost when the bot restarts
+// and we keep a history of everything that was deployed.
+type Store interface {
+	Save(d Deployment) error
+	Get(id string) (Deployment, error)
+	// Update loads a deployment, applies fn and saves the result atomically.
+	// Nothing is saved if fn returns an error.
+	Update(id string, fn func(d *Deployment) error) (Deployment, error)
+	List() ([]Deployment, error)
+	// Seen records a signature until it expires and reports whether it
+	// was already recorded, so replayed requests are rejected across restarts.
+	Seen(signature string, expires time.Time) (bool, error)
+	Close() error
+}
+
+var (
+	deploymentsBucket = []byte("deployments")
+	signaturesBucket  = []byte("signatures")
+)
+
+// boltStore is the default Store. It keeps everything in a single file
+// set with "storePath" in the config.
+// Only the ID of the project of a build is stored, projects looks up the
+// rest in the current config when a deployment is loaded.
+type boltStore struct {
+	db       *bolt.DB
+	projects func(id string) (Project, bool)
+}
+
+func newBoltStore(path string, projects func(id string) (Project, bool)) (*boltStore, error) {
+	if path == "" {
+		path = "ci-bot.db"
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deploymentsBucket)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.CreateBucketIfNotExists(signaturesBucket)
+		if err != nil {
+			return err
+		}
+
+		return rewriteDeployments(tx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltStore{db: db, projects: projects}, nil
+}
+
+// newStore() opens the store configured for the server
+func newStore(projects func(id string) (Project, bool)) (Store, error) {
+	return newBoltStore(viper.GetString("storePath"), projects)
+}
+
+func (bs *boltStore) Save(d Deployment) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return putDeployment(tx, &d)
+	})
+}
+
+func (bs *boltStore) Get(id string) (d Deployment, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		return bs.getDeployment(tx, id, &d)
+	})
+	return
+}
+
+func (bs *boltStore) Update(id string,
+	fn func(d *Deployment) error) (d Deployment, err error) {
+
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		err := bs.getDeployment(tx, id, &d)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&d)
+		if err != nil {
+			return err
+		}
+
+		return putDeployment(tx, &d)
+	})
+	return
+}
+
+func (bs *boltStore) List() (deployments []Deployment, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deploymentsBucket).ForEach(func(k, v []byte) error {
+			var d Deployment
+			err := bs.decode(v, &d)
+			if err != nil {
+				return err
+			}
+			deployments = append(deployments, d)
+			return nil
+		})
+	})
+	return
+}
+
+// Seen() keeps the expiry of each signature as a unix timestamp.
+// Expired ones are purged on every call.
+func (bs *boltStore) Seen(signature string, expires time.Time) (seen bool, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(signaturesBucket)
+		now := time.Now().Unix()
+
+		var expired [][]byte
+		err := bucket.ForEach(func(k, v []byte) error {
+			exp, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil || exp < now {
+				expired = append(expired, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, k := range expired {
+			err = bucket.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+
+		if bucket.Get([]byte(signature)) != nil {
+			seen = true
+			return nil
+		}
+
+		return bucket.Put([]byte(signature), []byte(strconv.FormatInt(expires.Unix(), 10)))
+	})
+	return
+}
+
+func (bs *boltStore) Close() error {
+	return bs.db.Close()
+}
+
+func putDeployment(tx *bolt.Tx, d *Deployment) error {
+	d.UpdatedAt = time.Now()
+
+	value, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+
+	return tx.Bucket(deploymentsBucket).Put([]byte(d.ID), value)
+}
+
+func (bs *boltStore) getDeployment(tx *bolt.Tx, id string, d *Deployment) error {
+	value := tx.Bucket(deploymentsBucket).Get([]byte(id))
+	if value == nil {
+		return errDeploymentNotFound
+	}
+
+	return bs.decode(value, d)
+}
+
+// rewriteDeployments() stores every deployment again so that records
+// written with the whole project, secrets included, only keep its ID
+func rewriteDeployments(tx *bolt.Tx) error {
+	bucket := tx.Bucket(deploymentsBucket)
+	rewritten := make(map[string][]byte)
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		var d Deployment
+		err := json.Unmarshal(v, &d)
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		rewritten[string(k)] = value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, v := range rewritten {
+		err = bucket.Put([]byte(k), v)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decode() reads a stored deployment and puts the project
+// as currently configured back into its build
+func (bs *boltStore) decode(value []byte, d *Deployment) error {
+	err := json.Unmarshal(value, d)
+	if err != nil {
+		return err
+	}
+
+	if project, ok := bs.projects(d.Build.Project.ID); ok {
+		d.Build.Project = project
+	}
+
+	return nil
+}
+
+// storedBuild is how a Build is kept in the Store.
+// The project is only stored by its ID so that secrets never end up on
+// disk and deployments always use the current config of their project.
+type storedBuild struct {
+	ProjectID string `json:"project_id"`
+	Target    string
+	Image     string
+	Type      string
+	Slug      string
+
+	// Records written before only the ID was stored have the whole project
+	Project *struct{ ID string } `json:",omitempty"`
+}
+
+func (b Build) MarshalJSON() ([]byte, error) {
+	return json.Marshal(storedBuild{
+		ProjectID: b.Project.ID,
+		Target:    b.Target,
+		Image:     b.Image,
+		Type:      b.Type,
+		Slug:      b.Slug,
+	})
+}
+
+func (b *Build) UnmarshalJSON(data []byte) error {
+	var sb storedBuild
+	err := json.Unmarshal(data, &sb)
+	if err != nil {
+		return err
+	}
+
+	*b = Build{
+		Project: Project{ID: sb.ProjectID},
+		Target:  sb.Target,
+		Image:   sb.Image,
+		Type:    sb.Type,
+		Slug:    sb.Slug,
+	}
+	if sb.ProjectID == "" && sb.Project != nil {
+		b.Project.ID = sb.Project.ID
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// usesPreview() reports whether the deployment is still being
+// looked at in its QA preview
+func (d Deployment) usesPreview() bool {
+	switch d.State {
+	case StateDeployingQA, StateQAReady, StateQAApproved, StateQARejected,
+		StatePromoting, StatePromoted:
+		return true
+	}
+	return false
+}
+
+// sameTarget() reports whether two builds share a QA preview
+func sameTarget(a, b Build) bool {
+	return a.Project.ID == b.Project.ID && a.Type == b.Type && a.slug() == b.slug()
+}
+
+// previewDeployments() returns the deployments using the QA preview of the build
+func (s *server) previewDeployments(build Build) (previews []Deployment, err error) {
+	deployments, err := s.Store.List()
+	if err != nil {
+		return
+	}
+
+	for _, d := range deployments {
+		if d.usesPreview() && sameTarget(d.Build, build) {
+			previews = append(previews, d)
+		}
+	}
+
+	return
+}
+
+// closeReplaced() closes the deployments waiting on QA whose preview now
+// runs the build of d, so nobody approves or deploys what QA no longer sees
+func (s *server) closeReplaced(d Deployment) (errs []error) {
+	previews, err := s.previewDeployments(d.Build)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	for _, other := range previews {
+		if other.ID == d.ID {
+			continue
+		}
+
+		switch other.State {
+		case StateQAReady, StateQAApproved, StateQARejected:
+		default:
+			continue
+		}
+
+		other, err := s.Store.Update(other.ID, func(other *Deployment) error {
+			other.ClosedAt = time.Now()
+			return other.Transition(StateClosed, "")
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		errs = append(errs, updateClosedOwnerMessages(other, "Replaced by a newer build")...)
+	}
+
+	return
+}
+
+// teardownPreview() deletes the QA preview of the build
+func (s *server) teardownPreview(build Build) error {
+	deployer, err := s.deployer(build.Project)
+	if err != nil {
+		return err
+	}
+
+	return deployer.Teardown(build)
+}
+
+// teardownIfUnused() deletes the QA preview of a closed deployment
+// unless a newer build of the same target is still using it
+func (s *server) teardownIfUnused(d Deployment) (errs []error) {
+	previews, err := s.previewDeployments(d.Build)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	for _, other := range previews {
+		if other.ID != d.ID {
+			return
+		}
+	}
+
+	err = s.teardownPreview(d.Build)
+	if err != nil {
+		errs = append(errs, err)
+		return
+	}
+
+	err = sendPreviewRemovedMessage(d, "Closed")
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return
+}
+
+// teardownTarget() deletes the QA preview of a branch or tag and closes
+// the deployments that were still waiting on QA in it. Deployments that
+// were promoted past QA stay open as they no longer need the preview.
+// The reason is shown to people.
+func (s *server) teardownTarget(build Build, reason string) {
+	previews, err := s.previewDeployments(build)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	err = s.teardownPreview(build)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, d := range previews {
+		switch d.State {
+		case StatePromoting, StatePromoted:
+			err = sendPreviewRemovedMessage(d, reason)
+			if err != nil {
+				log.Println(err)
+			}
+			continue
+		}
+
+		d, err := s.Store.Update(d.ID, func(d *Deployment) error {
+			d.ClosedAt = time.Now()
+			return d.Transition(StateClosed, "")
+		})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		errs := updateClosedOwnerMessages(d, reason)
+		if len(errs) > 0 {
+			log.Println(errs)
+		}
+
+		err = sendPreviewRemovedMessage(d, reason)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}